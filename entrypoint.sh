@@ -18,27 +18,89 @@ else
   exit 1
 fi
 
+if [ -n "$PAUS_DEPLOY_LOCK_TTL" ]; then
+  echo "DeployLockTTL=$PAUS_DEPLOY_LOCK_TTL" >> /paus/config
+fi
+
+if [ -n "$PAUS_DEPLOY_LOCK_TIMEOUT" ]; then
+  echo "DeployLockTimeout=$PAUS_DEPLOY_LOCK_TIMEOUT" >> /paus/config
+fi
+
 if [ -n "$PAUS_DOCKER_HOST" ]; then
   echo "DockerHost=$PAUS_DOCKER_HOST" >> /paus/config
 fi
 
+if [ -n "$PAUS_DRAIN_DELAY" ]; then
+  echo "DrainDelay=$PAUS_DRAIN_DELAY" >> /paus/config
+fi
+
+if [ -n "$PAUS_ETCD_API_VERSION" ]; then
+  echo "EtcdAPIVersion=$PAUS_ETCD_API_VERSION" >> /paus/config
+fi
+
 if [ -n "$PAUS_ETCD_ENDPOINT" ]; then
   echo "EtcdEndpoint=$PAUS_ETCD_ENDPOINT" >> /paus/config
 fi
 
+if [ -n "$PAUS_HEALTHCHECK_INTERVAL" ]; then
+  echo "HealthCheckInterval=$PAUS_HEALTHCHECK_INTERVAL" >> /paus/config
+fi
+
+if [ -n "$PAUS_HEALTHCHECK_MAX_TRY" ]; then
+  echo "HealthCheckMaxTry=$PAUS_HEALTHCHECK_MAX_TRY" >> /paus/config
+fi
+
+if [ -n "$PAUS_HEALTHCHECK_PATH" ]; then
+  echo "HealthCheckPath=$PAUS_HEALTHCHECK_PATH" >> /paus/config
+fi
+
 if [ -n "$PAUS_MAX_APP_DEPLOY" ]; then
   echo "MaxAppDeploy=$PAUS_MAX_APP_DEPLOY" >> /paus/config
 fi
 
+if [ -n "$PAUS_MAX_BRANCH_DEPLOY" ]; then
+  echo "MaxBranchDeploy=$PAUS_MAX_BRANCH_DEPLOY" >> /paus/config
+fi
+
+if [ -n "$PAUS_MAX_DEPLOY_AGE" ]; then
+  echo "MaxDeployAge=$PAUS_MAX_DEPLOY_AGE" >> /paus/config
+fi
+
+if [ -n "$PAUS_PROTECTED_BRANCHES" ]; then
+  echo "ProtectedBranches=$PAUS_PROTECTED_BRANCHES" >> /paus/config
+fi
+
 if [ -n "$PAUS_REPOSITORY_DIR" ]; then
   echo "RepositoryDir=$PAUS_REPOSITORY_DIR" >> /paus/config
   chown -R git:git $PAUS_REPOSITORY_DIR
 fi
 
+if [ -n "$PAUS_SUBMODULE_CACHE_DIR" ]; then
+  echo "SubmoduleCacheDir=$PAUS_SUBMODULE_CACHE_DIR" >> /paus/config
+  mkdir -p $PAUS_SUBMODULE_CACHE_DIR
+  chown -R git:git $PAUS_SUBMODULE_CACHE_DIR
+fi
+
+if [ -n "$PAUS_UNPACK_MAX_FILES" ]; then
+  echo "UnpackMaxFiles=$PAUS_UNPACK_MAX_FILES" >> /paus/config
+fi
+
+if [ -n "$PAUS_UNPACK_MAX_SIZE" ]; then
+  echo "UnpackMaxSize=$PAUS_UNPACK_MAX_SIZE" >> /paus/config
+fi
+
 if [ -n "$PAUS_URI_SCHEME" ]; then
   echo "URIScheme=$PAUS_URI_SCHEME" >> /paus/config
 fi
 
+if [ -n "$PAUS_WEBHOOK_SECRET" ]; then
+  echo "WebhookSecret=$PAUS_WEBHOOK_SECRET" >> /paus/config
+fi
+
+if [ -n "$PAUS_WEBHOOK_URLS" ]; then
+  echo "WebhookURLs=$PAUS_WEBHOOK_URLS" >> /paus/config
+fi
+
 if [ -n "$PAUS_DOCKER_CONFIG_BASE64" ]; then
   if [ ! -d /home/git/.docker ]; then
     mkdir /home/git/.docker
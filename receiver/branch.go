@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/dtan4/paus-gitreceive/receiver/config"
+	"github.com/dtan4/paus-gitreceive/receiver/model"
+	"github.com/dtan4/paus-gitreceive/receiver/store"
+	"github.com/dtan4/paus-gitreceive/receiver/vulcand"
+)
+
+// branchDeployments returns deployments recorded for the branch, and the one which the branch frontend currently points at
+func branchDeployments(st store.Store, deployment *model.Deployment, repositoryDir string) ([]*model.Deployment, error) {
+	application := deployment.App
+
+	deployments, err := application.BranchDeployments(deployment.Branch)
+
+	if err != nil {
+		return nil, err
+	}
+
+	currentBackend, err := vulcand.CurrentBranchBackend(st, deployment)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if currentBackend != "" {
+		revisionPrefix := strings.TrimPrefix(currentBackend, application.Repository+"-")
+
+		if timestamp, revision, err := application.FindDeployment(revisionPrefix); err == nil {
+			deployments[timestamp] = revision
+		}
+	}
+
+	result := []*model.Deployment{}
+
+	for timestamp := range deployments {
+		d, err := model.LoadDeployment(application, timestamp, repositoryDir)
+
+		if err != nil {
+			return nil, err
+		}
+
+		d.Branch = deployment.Branch
+		result = append(result, d)
+	}
+
+	return result, nil
+}
+
+// teardownBranch stops deployments of the deleted branch, and removes their routing information and records.
+// Deployments still routed from other frontends (e.g. other branches of the same revision) are kept running.
+func teardownBranch(config *config.Config, st store.Store, deployment *model.Deployment) error {
+	application := deployment.App
+
+	fmt.Println("=====> Branch " + deployment.Branch + " was deleted. Tearing down its deployments ...")
+
+	deployments, err := branchDeployments(st, deployment, config.RepositoryDir)
+
+	if err != nil {
+		return err
+	}
+
+	composes := map[string]*model.Compose{}
+	routes := map[string][]string{}
+	branchRoutes := []string{}
+
+	for _, d := range deployments {
+		compose, err := model.NewCompose(config.DockerHost, d.ComposeFilePath, d.ProjectName)
+
+		if err != nil {
+			return err
+		}
+
+		names, err := routeNames(compose)
+
+		if err != nil {
+			return err
+		}
+
+		composes[d.Timestamp] = compose
+		routes[d.Timestamp] = names
+		branchRoutes = append(branchRoutes, names...)
+	}
+
+	if err := vulcand.DeregisterBranch(st, deployment, branchRoutes); err != nil {
+		return err
+	}
+
+	for _, d := range deployments {
+		routed, err := vulcand.IsRouted(st, d)
+
+		if err != nil {
+			return err
+		}
+
+		if routed {
+			fmt.Println("=====> " + d.Revision + " is still routed from other frontends. Keep running.")
+			continue
+		}
+
+		fmt.Println("=====> Stop " + d.Revision + " ...")
+
+		if err := composes[d.Timestamp].Stop(); err != nil {
+			return err
+		}
+
+		if err := vulcand.DeregisterInformation(st, d, routes[d.Timestamp]); err != nil {
+			return err
+		}
+
+		if err := application.DeleteDeployment(d.Timestamp); err != nil {
+			return err
+		}
+
+		if err := application.DeleteEvents(d.Timestamp); err != nil {
+			return err
+		}
+	}
+
+	fmt.Println("=====> Branch " + deployment.Branch + " was torn down.")
+
+	return nil
+}
@@ -0,0 +1,114 @@
+package commitstatus
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	StateError   = "error"
+	StateFailure = "failure"
+	StatePending = "pending"
+	StateSuccess = "success"
+
+	DefaultAPIURL  = "https://api.github.com"
+	DefaultContext = "paus"
+
+	maxDescriptionLength = 140
+	requestTimeout       = 10 * time.Second
+)
+
+// Status is request body of GitHub commit status API
+type Status struct {
+	State       string `json:"state"`
+	TargetURL   string `json:"target_url,omitempty"`
+	Description string `json:"description,omitempty"`
+	Context     string `json:"context"`
+}
+
+// Reporter posts commit status to GitHub-compatible API
+type Reporter struct {
+	APIURL     string
+	Context    string
+	Repository string
+	Token      string
+
+	client *http.Client
+}
+
+// NewReporter returns reporter of the repository (owner/repo). Default values are used for empty apiURL and context.
+func NewReporter(apiURL, repository, token, context string) *Reporter {
+	if apiURL == "" {
+		apiURL = DefaultAPIURL
+	}
+
+	if context == "" {
+		context = DefaultContext
+	}
+
+	return &Reporter{
+		APIURL:     strings.TrimSuffix(apiURL, "/"),
+		Context:    context,
+		Repository: repository,
+		Token:      token,
+		client: &http.Client{
+			Timeout: requestTimeout,
+		},
+	}
+}
+
+// Report posts status of the revision. Description longer than API limit is truncated.
+func (r *Reporter) Report(revision, state, targetURL, description string) error {
+	if runes := []rune(description); len(runes) > maxDescriptionLength {
+		description = string(runes[0:maxDescriptionLength-3]) + "..."
+	}
+
+	status := &Status{
+		State:       state,
+		TargetURL:   targetURL,
+		Description: description,
+		Context:     r.Context,
+	}
+
+	body, err := json.Marshal(status)
+
+	if err != nil {
+		return errors.Wrap(err, "Failed to generate commit status JSON.")
+	}
+
+	url := r.APIURL + "/repos/" + r.Repository + "/statuses/" + revision
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+
+	if err != nil {
+		return errors.Wrapf(err, "Failed to create commit status request. url: %s", url)
+	}
+
+	req.Header.Set("Accept", "application/vnd.github.v3+json")
+	req.Header.Set("Content-Type", "application/json")
+
+	if r.Token != "" {
+		req.Header.Set("Authorization", "token "+r.Token)
+	}
+
+	resp, err := r.client.Do(req)
+
+	if err != nil {
+		return errors.Wrapf(err, "Failed to post commit status. url: %s", url)
+	}
+
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return errors.Errorf("Failed to post commit status. url: %s, status: %d", url, resp.StatusCode)
+	}
+
+	return nil
+}
@@ -0,0 +1,86 @@
+package commitstatus
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestReport(t *testing.T) {
+	var (
+		authorization string
+		path          string
+		status        Status
+	)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		path = r.URL.Path
+		body, _ := ioutil.ReadAll(r.Body)
+		json.Unmarshal(body, &status)
+
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	reporter := NewReporter(server.URL+"/", "dtan4/app", "token", "")
+
+	if err := reporter.Report("19fb23cd71a4cf2eab00ad1a393e40de4ed61531", StateSuccess, "http://dtan4-app-19fb23cd.pausapp.com", "Deployed to master"); err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	if expected := "/repos/dtan4/app/statuses/19fb23cd71a4cf2eab00ad1a393e40de4ed61531"; path != expected {
+		t.Fatalf("Request path does not match. expected: %s, actual: %s", expected, path)
+	}
+
+	if authorization != "token token" {
+		t.Fatalf("Authorization header does not match. expected: token token, actual: %s", authorization)
+	}
+
+	expected := Status{
+		State:       "success",
+		TargetURL:   "http://dtan4-app-19fb23cd.pausapp.com",
+		Description: "Deployed to master",
+		Context:     "paus",
+	}
+
+	if status != expected {
+		t.Fatalf("Status does not match. expected: %+v, actual: %+v", expected, status)
+	}
+
+	if err := reporter.Report("19fb23cd71a4cf2eab00ad1a393e40de4ed61531", StateFailure, "", strings.Repeat("x", 200)); err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	if len(status.Description) != maxDescriptionLength || !strings.HasSuffix(status.Description, "...") {
+		t.Fatalf("Long description should be truncated. actual: %s", status.Description)
+	}
+}
+
+func TestReportError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	reporter := NewReporter(server.URL, "dtan4/app", "invalid", "paus/deploy")
+
+	if err := reporter.Report("19fb23cd71a4cf2eab00ad1a393e40de4ed61531", StatePending, "", "Deploying"); err == nil {
+		t.Fatalf("Error should be raised when API returns error status.")
+	}
+}
+
+func TestNewReporter(t *testing.T) {
+	reporter := NewReporter("", "dtan4/app", "", "")
+
+	if reporter.APIURL != DefaultAPIURL {
+		t.Fatalf("APIURL does not match. expected: %s, actual: %s", DefaultAPIURL, reporter.APIURL)
+	}
+
+	if reporter.Context != DefaultContext {
+		t.Fatalf("Context does not match. expected: %s, actual: %s", DefaultContext, reporter.Context)
+	}
+}
@@ -19,21 +19,51 @@ const (
 var (
 	configNames = []string{
 		"BaseDomain",
+		"DeployLockTTL",
+		"DeployLockTimeout",
 		"DockerHost",
+		"DrainDelay",
+		"EtcdAPIVersion",
 		"EtcdEndpoint",
+		"HealthCheckInterval",
+		"HealthCheckMaxTry",
+		"HealthCheckPath",
 		"MaxAppDeploy",
+		"MaxBranchDeploy",
+		"MaxDeployAge",
+		"ProtectedBranches",
 		"RepositoryDir",
+		"SubmoduleCacheDir",
+		"UnpackMaxFiles",
+		"UnpackMaxSize",
 		"URIScheme",
+		"WebhookSecret",
+		"WebhookURLs",
 	}
 )
 
 type Config struct {
-	BaseDomain    string `envconfig:"base_domain"`
-	DockerHost    string `envconfig:"docker_host"    default:"tcp://localhost:2375"`
-	EtcdEndpoint  string `envconfig:"etcd_endpoint"  default:"http://localhost:2379"`
-	MaxAppDeploy  int64  `envconfig:"max_app_deploy" default:"10"`
-	RepositoryDir string `envconfig:"repository_dir" default:"/repos"`
-	URIScheme     string `envconfig:"uri_scheme"     default:"http"`
+	BaseDomain          string `envconfig:"base_domain"`
+	DeployLockTTL       int64  `envconfig:"deploy_lock_ttl"       default:"300"`
+	DeployLockTimeout   int64  `envconfig:"deploy_lock_timeout"   default:"600"`
+	DockerHost          string `envconfig:"docker_host"           default:"tcp://localhost:2375"`
+	DrainDelay          int64  `envconfig:"drain_delay"           default:"10"`
+	EtcdAPIVersion      int64  `envconfig:"etcd_api_version"      default:"2"`
+	EtcdEndpoint        string `envconfig:"etcd_endpoint"         default:"http://localhost:2379"`
+	HealthCheckInterval int64  `envconfig:"healthcheck_interval"  default:"2"`
+	HealthCheckMaxTry   int64  `envconfig:"healthcheck_max_try"   default:"30"`
+	HealthCheckPath     string `envconfig:"healthcheck_path"      default:"/"`
+	MaxAppDeploy        int64  `envconfig:"max_app_deploy"`
+	MaxBranchDeploy     int64  `envconfig:"max_branch_deploy"     default:"10"`
+	MaxDeployAge        int64  `envconfig:"max_deploy_age"        default:"0"`
+	ProtectedBranches   string `envconfig:"protected_branches"    default:"master"`
+	RepositoryDir       string `envconfig:"repository_dir"        default:"/repos"`
+	SubmoduleCacheDir   string `envconfig:"submodule_cache_dir"`
+	UnpackMaxFiles      int64  `envconfig:"unpack_max_files"      default:"100000"`
+	UnpackMaxSize       int64  `envconfig:"unpack_max_size"       default:"1073741824"`
+	URIScheme           string `envconfig:"uri_scheme"            default:"http"`
+	WebhookSecret       string `envconfig:"webhook_secret"`
+	WebhookURLs         string `envconfig:"webhook_urls"`
 }
 
 func loadConfigFromFile(filePath string) (map[string]string, error) {
@@ -73,7 +103,10 @@ func LoadConfig() (*Config, error) {
 		return nil, errors.Wrap(err, "Failed to load config from envs.")
 	}
 
+	maxBranchDeploySet := os.Getenv("PAUS_MAX_BRANCH_DEPLOY") != ""
+
 	if _, err := os.Stat(configFilePath); err != nil {
+		applyMaxAppDeploy(&config, maxBranchDeploySet)
 		return &config, nil
 	}
 
@@ -83,19 +116,41 @@ func LoadConfig() (*Config, error) {
 		return nil, err
 	}
 
+	if _, ok := configFromFile["MaxBranchDeploy"]; ok {
+		maxBranchDeploySet = true
+	}
+
 	for _, configName := range configNames {
-		if configName == "MaxAppDeploy" {
-			n, err := strconv.ParseInt(configFromFile[configName], 10, 64)
+		value, ok := configFromFile[configName]
+
+		if !ok {
+			continue
+		}
+
+		field := reflect.ValueOf(&config).Elem().FieldByName(configName)
+
+		switch field.Kind() {
+		case reflect.Int64:
+			n, err := strconv.ParseInt(value, 10, 64)
 
 			if err != nil {
-				return nil, errors.Wrapf(err, "Failed to parse %s as integer. value: %s", configName, configFromFile[configName])
+				return nil, errors.Wrapf(err, "Failed to parse %s as integer. value: %s", configName, value)
 			}
 
-			reflect.ValueOf(&config).Elem().FieldByName(configName).SetInt(n)
-		} else {
-			reflect.ValueOf(&config).Elem().FieldByName(configName).SetString(configFromFile[configName])
+			field.SetInt(n)
+		default:
+			field.SetString(value)
 		}
 	}
 
+	applyMaxAppDeploy(&config, maxBranchDeploySet)
+
 	return &config, nil
 }
+
+// applyMaxAppDeploy maps deprecated MaxAppDeploy to MaxBranchDeploy, unless MaxBranchDeploy is set explicitly
+func applyMaxAppDeploy(config *Config, maxBranchDeploySet bool) {
+	if config.MaxAppDeploy > 0 && !maxBranchDeploySet {
+		config.MaxBranchDeploy = config.MaxAppDeploy
+	}
+}
@@ -2,43 +2,390 @@ package main
 
 import (
 	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/dtan4/paus-gitreceive/receiver/commitstatus"
 	"github.com/dtan4/paus-gitreceive/receiver/config"
 	"github.com/dtan4/paus-gitreceive/receiver/model"
 	"github.com/dtan4/paus-gitreceive/receiver/store"
+	"github.com/dtan4/paus-gitreceive/receiver/submodule"
 	"github.com/dtan4/paus-gitreceive/receiver/util"
 	"github.com/dtan4/paus-gitreceive/receiver/vulcand"
+	"github.com/dtan4/paus-gitreceive/receiver/webhook"
+	"github.com/pkg/errors"
 )
 
-func deploy(application *model.Application, compose *model.Compose) (string, error) {
-	var err error
-
+// deploy builds and starts the project, and returns IDs of web service containers.
+// Containers are stopped if the project fails to start, because nothing is recorded to clean them up later.
+func deploy(compose *model.Compose, scale int, eventLog *model.EventLog) ([]string, error) {
 	fmt.Println("=====> Building ...")
 
-	if err = compose.Build(); err != nil {
-		return "", err
+	if err := eventLog.Record("build", compose.Build); err != nil {
+		return nil, err
 	}
 
 	fmt.Println("=====> Pulling ...")
 
-	if err = compose.Pull(); err != nil {
-		return "", err
+	if err := eventLog.Record("pull", compose.Pull); err != nil {
+		return nil, err
 	}
 
 	fmt.Println("=====> Deploying ...")
 
-	if err = compose.Up(); err != nil {
+	var webContainerIDs []string
+
+	err := eventLog.Record("up", func() error {
+		if err := compose.Up(); err != nil {
+			return err
+		}
+
+		ids, err := scaleWebService(compose, scale)
+
+		if err != nil {
+			return err
+		}
+
+		webContainerIDs = ids
+
+		return nil
+	})
+
+	if err != nil {
+		compose.Stop()
+		return nil, err
+	}
+
+	return webContainerIDs, nil
+}
+
+// unpackReceivedFiles unpacks pushed files, and returns the directory to build.
+// In incremental build, files are applied to the working copy of the app. It is safe to share the working copy
+// because deploys of the same app are serialized by deploy lock.
+func unpackReceivedFiles(config *config.Config, application *model.Application, deployment *model.Deployment, incremental bool) (string, error) {
+	limits := &util.UnpackLimits{
+		MaxFiles:     config.UnpackMaxFiles,
+		MaxTotalSize: config.UnpackMaxSize,
+	}
+
+	if !incremental {
+		return util.UnpackReceivedFiles(config.RepositoryDir, application.Username, deployment.ProjectName, os.Stdin, limits, util.PrintUnpackProgress)
+	}
+
+	workingCopyPath := application.WorkingCopyPath(config.RepositoryDir)
+
+	if err := util.UnpackWorkingCopy(workingCopyPath, os.Stdin, limits, util.PrintUnpackProgress); err != nil {
+		return "", err
+	}
+
+	// Compose file and deploy log of the deployment are saved in the project directory as usual
+	projectPath := filepath.Dir(deployment.ComposeFilePath)
+
+	if err := os.MkdirAll(projectPath, 0777); err != nil {
+		return "", errors.Wrapf(err, "Failed to create directory %s.", projectPath)
+	}
+
+	return workingCopyPath, nil
+}
+
+// pushedGitDir returns absolute path of the pushed repository. Receiver is run by git hook, in which GIT_DIR is set.
+// It must be called before changing working directory, because GIT_DIR may be relative.
+func pushedGitDir() (string, error) {
+	gitDir := os.Getenv("GIT_DIR")
+
+	if gitDir == "" {
+		gitDir = "."
+	}
+
+	path, err := filepath.Abs(gitDir)
+
+	if err != nil {
+		return "", errors.Wrapf(err, "Failed to resolve GIT_DIR %s.", gitDir)
+	}
+
+	return path, nil
+}
+
+// fetchSubmodules checks out submodules at the commits recorded in the pushed revision
+func fetchSubmodules(config *config.Config, application *model.Application, deployment *model.Deployment, gitDir, repositoryPath string) error {
+	credentials, err := application.GitCredentials()
+
+	if err != nil {
+		return err
+	}
+
+	// Mirrors are kept per app, so that repositories fetched with credentials of an app are not reused by other apps
+	cacheDir := ""
+
+	if config.SubmoduleCacheDir != "" {
+		cacheDir = filepath.Join(config.SubmoduleCacheDir, application.Username, application.AppName)
+	}
+
+	fetcher := submodule.NewFetcher(cacheDir, credentials, func(path, url, commit string) {
+		fmt.Println("       " + path + ": " + url + " @ " + commit)
+	})
+
+	return fetcher.Fetch(gitDir, deployment.Revision, repositoryPath)
+}
+
+// scaleWebService runs web service containers as many as scale, and returns their IDs
+func scaleWebService(compose *model.Compose, scale int) ([]string, error) {
+	if scale > 1 {
+		fmt.Println(fmt.Sprintf("=====> Scaling %s to %d containers ...", compose.WebService, scale))
+
+		if err := compose.Scale(compose.WebService, scale); err != nil {
+			return nil, err
+		}
+	}
+
+	containerIDs, err := compose.GetContainerIDs(compose.WebService)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(containerIDs) < scale {
+		return nil, errors.Errorf("Only %d of %d containers of %s are running.", len(containerIDs), scale, compose.WebService)
+	}
+
+	return containerIDs, nil
+}
+
+// webContainers inspects all web service containers
+func webContainers(dockerHost string, webContainerIDs []string, webPort string) ([]*model.Container, error) {
+	containers := []*model.Container{}
+
+	for _, containerID := range webContainerIDs {
+		container, err := model.ContainerFromID(dockerHost, containerID, webPort)
+
+		if err != nil {
+			return nil, err
+		}
+
+		containers = append(containers, container)
+	}
+
+	return containers, nil
+}
+
+// routeContainers returns containers of services routed with paus.route label, as a map of route name and container
+func routeContainers(compose *model.Compose, dockerHost string) (map[string]*model.Container, error) {
+	containers := map[string]*model.Container{}
+
+	routes, err := compose.RoutedServices()
+
+	if err != nil {
+		return nil, err
+	}
+
+	for route, service := range routes {
+		containerID, err := compose.GetContainerID(service)
+
+		if err != nil {
+			return nil, err
+		}
+
+		container, err := model.ContainerFromID(dockerHost, containerID, compose.ServicePort(service))
+
+		if err != nil {
+			return nil, err
+		}
+
+		containers[route] = container
+	}
+
+	return containers, nil
+}
+
+// routeNames returns route names of the deployment described in its compose file
+func routeNames(compose *model.Compose) ([]string, error) {
+	names := []string{}
+
+	routes, err := compose.RoutedServices()
+
+	if err != nil {
+		return nil, err
+	}
+
+	for route := range routes {
+		names = append(names, route)
+	}
+
+	return names, nil
+}
+
+// configureWebService decides the service and its container port to route.
+// App settings in etcd take precedence over labels in docker-compose.yml.
+func configureWebService(application *model.Application, compose *model.Compose) (string, error) {
+	webService, err := application.WebService()
+
+	if err != nil {
 		return "", err
 	}
 
-	webContainerID, err := compose.GetContainerID("web")
+	if webService != "" {
+		if !compose.HasService(webService) {
+			return "", errors.Errorf("Web service %s set in app settings is not defined in docker-compose.yml.", webService)
+		}
+
+		compose.WebService = webService
+	}
+
+	webPort, err := application.WebPort()
 
 	if err != nil {
 		return "", err
 	}
 
-	return webContainerID, nil
+	if webPort == "" {
+		webPort = compose.WebPort()
+	}
+
+	return webPort, nil
+}
+
+// drainPreviousDeployment stops the deployment which had served the branch before cutover, after waiting drainDelay seconds.
+// It is kept running if other frontends still point at it.
+func drainPreviousDeployment(store store.Store, deployment *model.Deployment, previousBackend string, drainDelay int64, dockerHost, repositoryDir string) error {
+	if previousBackend == "" || previousBackend == deployment.ProjectName {
+		return nil
+	}
+
+	application := deployment.App
+	revisionPrefix := strings.TrimPrefix(previousBackend, application.Repository+"-")
+
+	timestamp, _, err := application.FindDeployment(revisionPrefix)
+
+	if err != nil {
+		fmt.Println("=====> Previous deployment " + previousBackend + " was not found. Skip draining.")
+		return nil
+	}
+
+	previousDeployment, err := model.LoadDeployment(application, timestamp, repositoryDir)
+
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(fmt.Sprintf("=====> Draining previous deployment %s in %d seconds ...", previousDeployment.Revision, drainDelay))
+
+	time.Sleep(time.Duration(drainDelay) * time.Second)
+
+	routed, err := vulcand.IsRouted(store, previousDeployment)
+
+	if err != nil {
+		return err
+	}
+
+	if routed {
+		fmt.Println("=====> " + previousDeployment.Revision + " is still routed from other frontends. Keep running.")
+		return nil
+	}
+
+	fmt.Println("=====> Stop " + previousDeployment.Revision + " ...")
+
+	compose, err := model.NewCompose(dockerHost, previousDeployment.ComposeFilePath, previousDeployment.ProjectName)
+
+	if err != nil {
+		return err
+	}
+
+	if err := compose.Stop(); err != nil {
+		return err
+	}
+
+	routes, err := routeNames(compose)
+
+	if err != nil {
+		return err
+	}
+
+	if err := vulcand.DeregisterInformation(store, previousDeployment, routes); err != nil {
+		return err
+	}
+
+	if err := application.SetDeploymentStatus(previousDeployment.Timestamp, model.DeploymentStatusStopped); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// healthCheckDefaults returns default healthcheck settings, global config overridden by x-paus-healthcheck section in docker-compose.yml
+func healthCheckDefaults(config *config.Config, compose *model.Compose) map[string]string {
+	settings := map[string]string{
+		"interval": strconv.FormatInt(config.HealthCheckInterval, 10),
+		"max-try":  strconv.FormatInt(config.HealthCheckMaxTry, 10),
+		"path":     config.HealthCheckPath,
+	}
+
+	for k, v := range compose.HealthCheckSettings {
+		settings[k] = v
+	}
+
+	return settings
+}
+
+// healthCheck returns true only if all web containers are healthy
+func healthCheck(config *config.Config, application *model.Application, compose *model.Compose, webContainers []*model.Container) (bool, error) {
+	check, err := application.HealthCheck(healthCheckDefaults(config, compose))
+
+	if err != nil {
+		return false, err
+	}
+
+	callback := func(target string, try int) {
+		fmt.Println(fmt.Sprintf("      Ping to %s (%d times) ...", target, try))
+	}
+
+	for _, webContainer := range webContainers {
+		fmt.Println("=====> Start healthcheck of " + webContainer.ContainerId + " ...")
+
+		if !webContainer.ExecuteHealthCheck(check, callback) {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// runHook runs the hook command in a one-off container of web service.
+// Command set in app settings takes precedence over paus.hook.<hook> label in docker-compose.yml.
+func runHook(application *model.Application, compose *model.Compose, hook string) error {
+	command, err := application.HookCommand(hook)
+
+	if err != nil {
+		return err
+	}
+
+	if command == "" {
+		command = compose.HookCommand(hook)
+	}
+
+	if command == "" {
+		return nil
+	}
+
+	fmt.Println("=====> Running " + hook + " hook: " + command)
+
+	// One-off container shares environment variables of web service, so that secrets may be printed by the command
+	output := util.NewRedactor(compose.Secrets()).Writer(util.NewLineWriter())
+	exitCode, err := compose.RunOneOff(compose.WebService, []string{"/bin/sh", "-c", command}, output)
+	output.Close()
+
+	if err != nil {
+		return err
+	}
+
+	if exitCode != 0 {
+		return errors.Errorf("%s hook exited with status %d. command: %s", hook, exitCode, command)
+	}
+
+	return nil
 }
 
 func injectBuildArgs(application *model.Application, compose *model.Compose) error {
@@ -65,6 +412,17 @@ func injectEnvironmentVariables(application *model.Application, compose *model.C
 	return nil
 }
 
+// injectSecrets passes secrets of the app to web service. Secrets take precedence over environment variables.
+func injectSecrets(application *model.Application, compose *model.Compose) error {
+	secrets, err := application.Secrets()
+
+	if err != nil {
+		return err
+	}
+
+	return compose.InjectSecrets(secrets)
+}
+
 func prepareComposeFile(application *model.Application, deployment *model.Deployment, compose *model.Compose) error {
 	if err := injectBuildArgs(application, compose); err != nil {
 		return err
@@ -74,6 +432,10 @@ func prepareComposeFile(application *model.Application, deployment *model.Deploy
 		return err
 	}
 
+	if err := injectSecrets(application, compose); err != nil {
+		return err
+	}
+
 	compose.RewritePortBindings()
 
 	if err := compose.SaveAs(deployment.ComposeFilePath); err != nil {
@@ -83,52 +445,242 @@ func prepareComposeFile(application *model.Application, deployment *model.Deploy
 	return nil
 }
 
-func printDeployedURLs(repository string, config *config.Config, identifiers []string) {
-	var url string
+func deployedURLs(config *config.Config, identifiers []string) []string {
+	urls := []string{}
+
+	for _, identifier := range identifiers {
+		urls = append(urls, strings.ToLower(config.URIScheme+"://"+identifier+"."+config.BaseDomain))
+	}
 
+	return urls
+}
+
+func printDeployedURLs(repository string, config *config.Config, identifiers []string) {
 	fmt.Println("=====> " + repository + " was successfully deployed at:")
 
-	for _, identifier := range identifiers {
-		url = strings.ToLower(config.URIScheme + "://" + identifier + "." + config.BaseDomain)
+	for _, url := range deployedURLs(config, identifiers) {
 		fmt.Println("         " + url)
 	}
 }
 
-func rotateDeployments(etcd *store.Etcd, application *model.Application, maxAppDeploy int64, dockerHost string, repositoryDir string) error {
-	deployments, err := application.Deployments()
+// newNotifier returns webhook notifier which posts to both global and app endpoints.
+// App webhook secret takes precedence over global one.
+func newNotifier(config *config.Config, application *model.Application) (*webhook.Notifier, error) {
+	urls := []string{}
+
+	for _, url := range strings.Split(config.WebhookURLs, ",") {
+		if url = strings.TrimSpace(url); url != "" {
+			urls = append(urls, url)
+		}
+	}
+
+	appURLs, err := application.Webhooks()
 
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	if len(deployments) == 0 || int64(len(deployments)) < maxAppDeploy {
-		return nil
+	secret, err := application.WebhookSecret()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if secret == "" {
+		secret = config.WebhookSecret
+	}
+
+	return webhook.NewNotifier(append(urls, appURLs...), secret), nil
+}
+
+// newReporter returns commit status reporter of the application, or nil if reporting is not configured
+func newReporter(application *model.Application) (*commitstatus.Reporter, error) {
+	settings, err := application.CommitStatus()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if settings["repository"] == "" {
+		return nil, nil
 	}
 
-	fmt.Println("=====> Max deploy limit reached.")
+	return commitstatus.NewReporter(settings["api-url"], settings["repository"], settings["token"], settings["context"]), nil
+}
 
-	oldestTimestamp := util.SortKeys(deployments)[0]
-	oldestDeployment := model.NewDeployment(application, "", deployments[oldestTimestamp], oldestTimestamp, repositoryDir)
+// reportStatus posts commit status of the deployed revision. Failure of reporting does not abort deploy.
+func reportStatus(reporter *commitstatus.Reporter, deployment *model.Deployment, state, targetURL, description string) {
+	if reporter == nil {
+		return
+	}
 
-	fmt.Println("=====> Stop " + oldestDeployment.Revision + " ...")
+	if err := reporter.Report(deployment.Revision, state, targetURL, description); err != nil {
+		fmt.Fprintf(os.Stderr, "=====> Failed to report commit status. error: %s\n", err)
+	}
+}
 
-	compose, err := model.NewCompose(dockerHost, oldestDeployment.ComposeFilePath, oldestDeployment.ProjectName)
+// notify sends deploy lifecycle event to webhooks. Failure of notification does not abort deploy.
+func notify(notifier *webhook.Notifier, event string, deployment *model.Deployment, urls []string, cause error) {
+	payload := &webhook.Payload{
+		Event:      event,
+		User:       deployment.App.Username,
+		App:        deployment.App.AppName,
+		Repository: deployment.App.Repository,
+		Branch:     deployment.Branch,
+		Revision:   deployment.Revision,
+		Timestamp:  deployment.Timestamp,
+		URLs:       urls,
+	}
+
+	if cause != nil {
+		payload.Error = cause.Error()
+	}
+
+	if err := notifier.Notify(payload); err != nil {
+		fmt.Fprintf(os.Stderr, "=====> Failed to notify %s event. error: %s\n", event, err)
+	}
+}
+
+// registerDeployment registers vulcand routing information and deployment metadata at once.
+// If any step fails, all written keys are restored and the compose project is stopped.
+func registerDeployment(st store.Store, deployment *model.Deployment, compose *model.Compose, config *config.Config, webContainers []*model.Container, routeContainers map[string]*model.Container) ([]string, error) {
+	tx := store.NewTransaction(st)
+
+	d := *deployment
+	d.App = deployment.App.WithStore(tx)
+
+	identifiers, err := vulcand.RegisterInformation(tx, &d, config.BaseDomain, webContainers, routeContainers)
 
 	if err != nil {
-		return err
+		return nil, rollbackDeployment(tx, compose, err)
+	}
+
+	d.ContainerIDs = containerIDs(webContainers, routeContainers)
+	d.Status = model.DeploymentStatusActive
+	d.URLs = deployedURLs(config, identifiers)
+
+	if err := d.Register(); err != nil {
+		return nil, rollbackDeployment(tx, compose, err)
+	}
+
+	tx.Commit()
+
+	return identifiers, nil
+}
+
+// containerIDs returns IDs of web containers and routed containers
+func containerIDs(webContainers []*model.Container, routeContainers map[string]*model.Container) []string {
+	ids := []string{}
+
+	for _, container := range webContainers {
+		ids = append(ids, container.ContainerId)
+	}
+
+	routeIDs := []string{}
+
+	for _, container := range routeContainers {
+		routeIDs = append(routeIDs, container.ContainerId)
+	}
+
+	sort.Strings(routeIDs)
+
+	return append(ids, routeIDs...)
+}
+
+func rollbackDeployment(tx *store.Transaction, compose *model.Compose, cause error) error {
+	fmt.Fprintln(os.Stderr, "=====> Failed to register deployment. Rolling back ...")
+
+	if err := tx.Rollback(); err != nil {
+		return errors.Wrapf(err, "Failed to roll back deployment. cause: %v", cause)
 	}
 
 	if err := compose.Stop(); err != nil {
-		return err
+		return errors.Wrapf(err, "Failed to stop containers. cause: %v", cause)
 	}
 
-	if err := application.DeleteDeployment(oldestTimestamp); err != nil {
+	return cause
+}
+
+// retentionPolicy returns retention policy of the application, global config overridden by app settings
+func retentionPolicy(config *config.Config, application *model.Application) (*model.RetentionPolicy, error) {
+	return application.RetentionPolicy(&model.RetentionPolicy{
+		MaxAge:            config.MaxDeployAge,
+		MaxPerBranch:      config.MaxBranchDeploy,
+		ProtectedBranches: model.ParseBranches(config.ProtectedBranches),
+	})
+}
+
+// rotateDeployments stops and removes deployments evicted by retention policy.
+// The new deployment and deployments still routed from any branch are kept.
+func rotateDeployments(store store.Store, deployment *model.Deployment, policy *model.RetentionPolicy, dockerHost string, repositoryDir string, notifier *webhook.Notifier) error {
+	application := deployment.App
+	records, err := application.DeploymentRecords()
+
+	if err != nil {
 		return err
 	}
 
-	if err := vulcand.DeregisterInformation(etcd, oldestDeployment); err != nil {
+	branches := map[string]string{}
+
+	for timestamp, record := range records {
+		branches[timestamp] = record.Branch
+	}
+
+	evicted, err := policy.Evict(branches, time.Now())
+
+	if err != nil {
 		return err
 	}
 
+	for _, timestamp := range evicted {
+		if timestamp == deployment.Timestamp {
+			continue
+		}
+
+		oldDeployment := model.DeploymentFromRecord(application, timestamp, records[timestamp], repositoryDir)
+
+		routed, err := vulcand.IsRouted(store, oldDeployment)
+
+		if err != nil {
+			return err
+		}
+
+		if routed {
+			continue
+		}
+
+		fmt.Println("=====> Stop " + oldDeployment.Revision + " (deployed at " + timestamp + ") by retention policy ...")
+
+		compose, err := model.NewCompose(dockerHost, oldDeployment.ComposeFilePath, oldDeployment.ProjectName)
+
+		if err != nil {
+			return err
+		}
+
+		if err := compose.Stop(); err != nil {
+			return err
+		}
+
+		if err := application.DeleteDeployment(timestamp); err != nil {
+			return err
+		}
+
+		if err := application.DeleteEvents(timestamp); err != nil {
+			return err
+		}
+
+		routes, err := routeNames(compose)
+
+		if err != nil {
+			return err
+		}
+
+		if err := vulcand.DeregisterInformation(store, oldDeployment, routes); err != nil {
+			return err
+		}
+
+		notify(notifier, webhook.EventRotated, oldDeployment, nil, nil)
+	}
+
 	return nil
 }
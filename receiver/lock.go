@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/dtan4/paus-gitreceive/receiver/config"
+	"github.com/dtan4/paus-gitreceive/receiver/model"
+	"github.com/pkg/errors"
+)
+
+const (
+	deployLockInterval = 5 * time.Second
+)
+
+// acquireDeployLock acquires deploy lock of the application.
+// If another deploy holds the lock, it waits up to config.DeployLockTimeout seconds, or fails immediately if the timeout is 0.
+func acquireDeployLock(config *config.Config, application *model.Application, holder *model.LockHolder) (*model.DeployLock, error) {
+	lock := model.NewDeployLock(application, holder, time.Duration(config.DeployLockTTL)*time.Second)
+
+	if config.DeployLockTimeout == 0 {
+		acquired, err := lock.TryAcquire()
+
+		if err != nil {
+			return nil, err
+		}
+
+		if acquired {
+			return lock, nil
+		}
+
+		current, err := lock.Holder()
+
+		if err != nil {
+			return nil, err
+		}
+
+		if current == nil {
+			return nil, errors.Errorf("Another deploy of %s is in progress.", application.Repository)
+		}
+
+		return nil, errors.Errorf("Another deploy of %s is in progress by %s.", application.Repository, current)
+	}
+
+	var waitingFor string
+
+	err := lock.Acquire(time.Duration(config.DeployLockTimeout)*time.Second, deployLockInterval, func(current *model.LockHolder) {
+		if current.Token == waitingFor {
+			return
+		}
+
+		waitingFor = current.Token
+		fmt.Println("=====> Another deploy of " + application.Repository + " is in progress by " + current.String() + ". Waiting ...")
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return lock, nil
+}
+
+// releaseDeployLock releases deploy lock. Failure is only reported, because the lock expires after its TTL anyway.
+func releaseDeployLock(lock *model.DeployLock) {
+	if err := lock.Release(); err != nil {
+		fmt.Printf("=====> Failed to release deploy lock. error: %s\n", err)
+	}
+}
@@ -5,27 +5,34 @@ import (
 	"os"
 	"path/filepath"
 
+	"github.com/dtan4/paus-gitreceive/receiver/commitstatus"
 	"github.com/dtan4/paus-gitreceive/receiver/config"
 	"github.com/dtan4/paus-gitreceive/receiver/model"
 	"github.com/dtan4/paus-gitreceive/receiver/store"
 	"github.com/dtan4/paus-gitreceive/receiver/util"
 	"github.com/dtan4/paus-gitreceive/receiver/vulcand"
+	"github.com/dtan4/paus-gitreceive/receiver/webhook"
+	"github.com/pkg/errors"
 )
 
-func initialize() (*config.Config, *store.Etcd, error) {
+func initialize() (*config.Config, store.Store, error) {
 	config, err := config.LoadConfig()
 
 	if err != nil {
 		return nil, nil, err
 	}
 
-	etcd, err := store.NewEtcd(config.EtcdEndpoint)
+	if config.MaxAppDeploy > 0 {
+		fmt.Fprintln(os.Stderr, "=====> PAUS_MAX_APP_DEPLOY is deprecated. Use PAUS_MAX_BRANCH_DEPLOY instead.")
+	}
+
+	store, err := store.NewEtcdStore(config.EtcdEndpoint, config.EtcdAPIVersion)
 
 	if err != nil {
 		return nil, nil, err
 	}
 
-	return config, etcd, nil
+	return config, store, nil
 }
 
 func main() {
@@ -36,14 +43,23 @@ func main() {
 		}
 	}
 
-	config, etcd, err := initialize()
+	config, store, err := initialize()
 
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "%+v\n", err)
 		os.Exit(1)
 	}
 
-	application, err := model.ApplicationFromArgs(os.Args[1:], etcd)
+	if len(os.Args) > 1 && os.Args[1] == "rollback" {
+		if err := rollback(config, store, os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "%+v\n", err)
+			os.Exit(1)
+		}
+
+		os.Exit(0)
+	}
+
+	application, err := model.ApplicationFromArgs(os.Args[1:], store)
 
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "%+v\n", err)
@@ -55,113 +71,286 @@ func main() {
 		os.Exit(1)
 	}
 
-	deployment, err := model.DeploymentFromArgs(application, os.Args[1:], "", config.RepositoryDir)
+	deployment, err := model.DeploymentFromArgs(application, os.Args[1:], util.Timestamp(), config.RepositoryDir)
 
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "%+v\n", err)
 		os.Exit(1)
 	}
 
-	repositoryPath, err := util.UnpackReceivedFiles(config.RepositoryDir, application.Username, deployment.ProjectName, os.Stdin)
+	lock, err := acquireDeployLock(config, application, model.NewLockHolder(deployment))
 
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "%+v\n", err)
 		os.Exit(1)
 	}
 
-	if err = os.Chdir(repositoryPath); err != nil {
+	// exit releases deploy lock before exiting, because deferred functions are not run by os.Exit
+	exit := func(code int) {
+		releaseDeployLock(lock)
+		os.Exit(code)
+	}
+
+	if deployment.BranchDeleted {
+		if err := teardownBranch(config, store, deployment); err != nil {
+			fmt.Fprintf(os.Stderr, "%+v\n", err)
+			exit(1)
+		}
+
+		exit(0)
+	}
+
+	notifier, err := newNotifier(config, application)
+
+	if err != nil {
 		fmt.Fprintf(os.Stderr, "%+v\n", err)
-		os.Exit(1)
+		exit(1)
 	}
 
-	fmt.Println("=====> Getting submodules ...")
+	reporter, err := newReporter(application)
 
-	if err = util.GetSubmodules(repositoryPath); err != nil {
+	if err != nil {
 		fmt.Fprintf(os.Stderr, "%+v\n", err)
-		os.Exit(1)
+		exit(1)
+	}
+
+	secrets, err := application.Secrets()
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%+v\n", err)
+		exit(1)
+	}
+
+	redactor := util.NewRedactor(secrets)
+
+	// fail aborts deploy and notifies the failure to webhooks and commit status. Secrets in error message are masked.
+	fail := func(err error) {
+		fmt.Fprintln(os.Stderr, redactor.Redact(fmt.Sprintf("%+v", err)))
+		err = redactor.RedactError(err)
+		notify(notifier, webhook.EventFailed, deployment, nil, err)
+		reportStatus(reporter, deployment, commitstatus.StateFailure, "", "Deploy failed: "+err.Error())
+		exit(1)
+	}
+
+	// warn reports failure after cutover, which must not be notified as deploy failure because the deployment is already live
+	warn := func(err error) {
+		fmt.Fprintln(os.Stderr, "=====> Warning: "+redactor.Redact(fmt.Sprintf("%+v", err)))
+	}
+
+	notify(notifier, webhook.EventStarted, deployment, nil, nil)
+	reportStatus(reporter, deployment, commitstatus.StatePending, "", "Deploying to "+deployment.Branch)
+
+	eventLog := model.NewEventLog(deployment)
+	eventLog.SetRedactor(redactor)
+
+	incremental, err := application.IncrementalBuild()
+
+	if err != nil {
+		fail(err)
+	}
+
+	gitDir, err := pushedGitDir()
+
+	if err != nil {
+		fail(err)
+	}
+
+	var repositoryPath string
+
+	err = eventLog.Record("unpack", func() error {
+		path, err := unpackReceivedFiles(config, application, deployment, incremental)
+
+		if err != nil {
+			return err
+		}
+
+		repositoryPath = path
+
+		return nil
+	})
+
+	if err != nil {
+		fail(err)
+	}
+
+	if err = os.Chdir(repositoryPath); err != nil {
+		fail(err)
+	}
+
+	fmt.Println("=====> Getting submodules ...")
+
+	err = eventLog.Record("submodules", func() error {
+		return fetchSubmodules(config, application, deployment, gitDir, repositoryPath)
+	})
+
+	if err != nil {
+		fail(err)
 	}
 
 	composeFilePath := filepath.Join(repositoryPath, "docker-compose.yml")
 
 	if _, err := os.Stat(composeFilePath); err != nil {
-		fmt.Fprintf(os.Stderr, "%+v\n", err)
-		os.Exit(1)
+		fail(err)
 	}
 
 	fmt.Println("=====> docker-compose.yml was found")
 
-	if err := rotateDeployments(etcd, application, config.MaxAppDeploy, config.DockerHost, config.RepositoryDir); err != nil {
-		fmt.Fprintf(os.Stderr, "%+v\n", err)
-		os.Exit(1)
+	compose, err := model.NewCompose(config.DockerHost, composeFilePath, deployment.ProjectName)
+
+	if err != nil {
+		fail(err)
 	}
 
-	compose, err := model.NewCompose(config.DockerHost, composeFilePath, deployment.ProjectName)
+	webPort, err := configureWebService(application, compose)
 
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "%+v\n", err)
-		os.Exit(1)
+		fail(err)
+	}
+
+	scale, err := application.Scale()
+
+	if err != nil {
+		fail(err)
 	}
 
+	// Recorded to roll back to the same containers regardless of later app settings
+	deployment.WebService = compose.WebService
+	deployment.WebPort = webPort
+	deployment.Scale = scale
+
 	if err := prepareComposeFile(application, deployment, compose); err != nil {
-		fmt.Fprintf(os.Stderr, "%+v\n", err)
-		os.Exit(1)
+		fail(err)
 	}
 
-	webContainerID, err := deploy(application, compose)
+	webContainerIDs, err := deploy(compose, scale, eventLog)
 
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "%+v\n", err)
-		os.Exit(1)
+		fail(err)
 	}
 
 	fmt.Println("=====> Application container is launched.")
 
-	webContainer, err := model.ContainerFromID(config.DockerHost, webContainerID)
+	webContainers, err := webContainers(config.DockerHost, webContainerIDs, webPort)
 
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "%+v\n", err)
-		os.Exit(1)
+		compose.Stop()
+		fail(err)
 	}
 
-	path, interval, maxTry, err := application.HealthCheck()
+	err = eventLog.Record(model.HookPreDeploy, func() error {
+		return runHook(application, compose, model.HookPreDeploy)
+	})
 
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "%+v\n", err)
-		os.Exit(1)
+		compose.Stop()
+		fail(err)
 	}
 
-	callback := func(path string, try int) {
-		fmt.Println(fmt.Sprintf("      Ping to %s (%d times) ...", path, try))
+	err = eventLog.Record("healthcheck", func() error {
+		healthy, err := healthCheck(config, application, compose, webContainers)
+
+		if err != nil {
+			return err
+		}
+
+		if !healthy {
+			return errors.New("Web container is not active.")
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		compose.Stop()
+		fail(err)
 	}
 
-	fmt.Println("=====> Start healthcheck ...")
+	err = eventLog.Record(model.HookPostDeploy, func() error {
+		return runHook(application, compose, model.HookPostDeploy)
+	})
 
-	if !webContainer.ExecuteHealthCheck(path, interval, maxTry, callback) {
-		fmt.Fprintln(os.Stderr, "=====> Web container is not active. Aborted.")
+	if err != nil {
 		compose.Stop()
-		os.Exit(1)
+		fail(err)
 	}
 
-	fmt.Println("=====> Registering metadata ...")
+	if err := lock.Check(); err != nil {
+		compose.Stop()
+		fail(err)
+	}
 
-	deployment.Timestamp = util.Timestamp()
+	// Read before registering, because the branch frontend is switched to the new deployment on register
+	previousBackend, err := vulcand.CurrentBranchBackend(store, deployment)
 
-	if err = deployment.Register(); err != nil {
-		fmt.Fprintf(os.Stderr, "%+v\n", err)
-		os.Exit(1)
+	if err != nil {
+		compose.Stop()
+		fail(err)
 	}
 
-	identifiers, err := vulcand.RegisterInformation(etcd, deployment, config.BaseDomain, webContainer)
+	fmt.Println("=====> Registering metadata ...")
+
+	var identifiers []string
+
+	err = eventLog.Record("register", func() error {
+		routeContainers, err := routeContainers(compose, config.DockerHost)
+
+		if err != nil {
+			compose.Stop()
+			return err
+		}
+
+		identifiers, err = registerDeployment(store, deployment, compose, config, webContainers, routeContainers)
+
+		return err
+	})
 
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "%+v\n", err)
-		os.Exit(1)
+		fail(err)
 	}
 
 	printDeployedURLs(application.Repository, config, identifiers)
+	notify(notifier, webhook.EventSucceeded, deployment, deployedURLs(config, identifiers), nil)
+	reportStatus(reporter, deployment, commitstatus.StateSuccess, deployedURLs(config, []string{deployment.ProjectName})[0], "Deployed to "+deployment.Branch)
 
-	if err = util.RemoveUnpackedFiles(repositoryPath, deployment.ComposeFilePath); err != nil {
-		fmt.Fprintf(os.Stderr, "%+v\n", err)
-		os.Exit(1)
+	// Previous deployments may be used by another deploy holding the lock, so that they are left as they are
+	if err := lock.Check(); err != nil {
+		warn(errors.Wrap(err, "Skipped draining and rotating deployments."))
+		exit(0)
 	}
+
+	err = eventLog.Record("drain", func() error {
+		return drainPreviousDeployment(store, deployment, previousBackend, config.DrainDelay, config.DockerHost, config.RepositoryDir)
+	})
+
+	if err != nil {
+		warn(err)
+	}
+
+	if err := lock.Check(); err != nil {
+		warn(errors.Wrap(err, "Skipped rotating deployments."))
+		exit(0)
+	}
+
+	err = eventLog.Record("rotate", func() error {
+		policy, err := retentionPolicy(config, application)
+
+		if err != nil {
+			return err
+		}
+
+		return rotateDeployments(store, deployment, policy, config.DockerHost, config.RepositoryDir, notifier)
+	})
+
+	if err != nil {
+		warn(err)
+	}
+
+	// Working copy is kept for the next incremental build
+	if !incremental {
+		if err = util.RemoveUnpackedFiles(repositoryPath, deployment.ComposeFilePath, deployment.LogFilePath); err != nil {
+			warn(err)
+		}
+	}
+
+	releaseDeployLock(lock)
 }
@@ -1,24 +1,32 @@
 package model
 
 import (
+	"encoding/json"
+	"path/filepath"
 	"strconv"
 	"strings"
 
 	"github.com/dtan4/paus-gitreceive/receiver/store"
+	"github.com/dtan4/paus-gitreceive/receiver/util"
 	"github.com/pkg/errors"
 )
 
+const (
+	HookPostDeploy = "post-deploy"
+	HookPreDeploy  = "pre-deploy"
+)
+
 type Application struct {
 	Repository string
 	Username   string
 	AppName    string
 
-	etcd *store.Etcd
+	store store.Store
 }
 
 // args:
 //  user/app, 19fb23cd71a4cf2eab00ad1a393e40de4ed61531, user, 4c:1f:92:b9:43:2b:23:0b:c0:e8:ab:12:cd:34:ef:56, refs/heads/branch-name
-func ApplicationFromArgs(args []string, etcd *store.Etcd) (*Application, error) {
+func ApplicationFromArgs(args []string, store store.Store) (*Application, error) {
 	if len(args) < 5 {
 		return nil, errors.Errorf("5 arguments (repository, revision, username, fingerprint, refname) must be passed. got: %d", len(args))
 	}
@@ -31,39 +39,129 @@ func ApplicationFromArgs(args []string, etcd *store.Etcd) (*Application, error)
 		Repository: repository,
 		Username:   username,
 		AppName:    appName,
-		etcd:       etcd,
+		store:      store,
 	}, nil
 }
 
+// repository: user/app
+func ApplicationFromRepository(repository string, store store.Store) (*Application, error) {
+	ss := strings.SplitN(repository, "/", 2)
+
+	if len(ss) != 2 || ss[0] == "" || ss[1] == "" {
+		return nil, errors.Errorf("Repository must be formatted as user/app. got: %s", repository)
+	}
+
+	return &Application{
+		Repository: strings.Replace(repository, "/", "-", -1),
+		Username:   ss[0],
+		AppName:    strings.Replace(ss[1], "/", "-", -1),
+		store:      store,
+	}, nil
+}
+
+// WebPort returns container port of web service to route, or empty string if it is not set
+func (app *Application) WebPort() (string, error) {
+	return app.optionalValue("web-port")
+}
+
+// Scale returns number of web service containers to run, or 1 if it is not set
+func (app *Application) Scale() (int, error) {
+	value, err := app.optionalValue("scale")
+
+	if err != nil {
+		return 0, err
+	}
+
+	if value == "" {
+		return 1, nil
+	}
+
+	scale, err := strconv.Atoi(value)
+
+	if err != nil {
+		return 0, errors.Wrapf(err, "Scale must be an integer. scale: %s", value)
+	}
+
+	if scale < 1 {
+		return 0, errors.Errorf("Scale must be greater than 0. scale: %d", scale)
+	}
+
+	return scale, nil
+}
+
+// HookCommand returns command of the hook, or empty string if it is not set
+func (app *Application) HookCommand(hook string) (string, error) {
+	return app.optionalValue("hooks/" + hook)
+}
+
+// IncrementalBuild returns whether pushed files are applied to the working copy of the app, which is kept between deployments
+func (app *Application) IncrementalBuild() (bool, error) {
+	value, err := app.optionalValue("build/incremental")
+
+	if err != nil {
+		return false, err
+	}
+
+	if value == "" {
+		return false, nil
+	}
+
+	incremental, err := strconv.ParseBool(value)
+
+	if err != nil {
+		return false, errors.Wrapf(err, "build/incremental must be a boolean. value: %s", value)
+	}
+
+	return incremental, nil
+}
+
+// WorkingCopyPath returns path of the working copy used in incremental build
+func (app *Application) WorkingCopyPath(repositoryDir string) string {
+	return filepath.Join(repositoryDir, app.Username, ".worktree", app.Repository)
+}
+
+// WebService returns name of compose service to route, or empty string if it is not set
+func (app *Application) WebService() (string, error) {
+	return app.optionalValue("web-service")
+}
+
+// WithStore returns a copy of the application which reads and writes metadata through the given store
+func (app *Application) WithStore(store store.Store) *Application {
+	a := *app
+	a.store = store
+
+	return &a
+}
+
 func (app *Application) BuildArgs() (map[string]string, error) {
 	var args = make(map[string]string)
 
 	userDirectoryKey := "/paus/users/" + app.Username
 
-	if !app.etcd.HasKey(userDirectoryKey) {
+	if !app.store.HasKey(userDirectoryKey) {
 		return map[string]string{}, nil
 	}
 
 	appDirectoryKey := userDirectoryKey + "/apps/" + app.AppName
 
-	if !app.etcd.HasKey(appDirectoryKey) {
+	if !app.store.HasKey(appDirectoryKey) {
 		return map[string]string{}, nil
 	}
 
 	buildArgsKey := appDirectoryKey + "/build-args/"
 
-	if !app.etcd.HasKey(buildArgsKey) {
+	if !app.store.HasKey(buildArgsKey) {
 		return map[string]string{}, nil
 	}
 
-	buildArgKeys, err := app.etcd.List(buildArgsKey, false)
+	buildArgKeys, err := app.store.List(buildArgsKey, false)
 
 	if err != nil {
 		return nil, err
 	}
 
 	for _, key := range buildArgKeys {
-		value, err := app.etcd.Get(key)
+		value, err := app.store.Get(key)
 
 		if err != nil {
 			return nil, err
@@ -75,41 +173,159 @@ func (app *Application) BuildArgs() (map[string]string, error) {
 	return args, nil
 }
 
+// DeleteDeployment removes the deployment record
 func (app *Application) DeleteDeployment(deployment string) error {
-	key := "/paus/users/" + app.Username + "/apps/" + app.AppName + "/deployments/" + deployment
+	return app.store.Delete(deploymentKey(app, deployment))
+}
 
-	if err := app.etcd.Delete(key); err != nil {
-		return err
+// BranchDeployments returns deployments of the branch, as a map of timestamp and revision.
+// Branch is taken from deployment records, so that deployments of old format without branch are not included.
+func (app *Application) BranchDeployments(branch string) (map[string]string, error) {
+	records, err := app.DeploymentRecords()
+
+	if err != nil {
+		return nil, err
 	}
 
-	return nil
+	deployments := map[string]string{}
+
+	for timestamp, record := range records {
+		if record.Branch == branch {
+			deployments[timestamp] = record.Revision
+		}
+	}
+
+	return deployments, nil
+}
+
+// eventsKey returns the key which stores events of the deployment
+func eventsKey(app *Application, timestamp string) string {
+	return "/paus/users/" + app.Username + "/apps/" + app.AppName + "/events/" + timestamp
+}
+
+// Events returns recorded events of the deployment
+func (app *Application) Events(timestamp string) ([]*Event, error) {
+	key := eventsKey(app, timestamp)
+	events := []*Event{}
+
+	if !app.store.HasKey(key) {
+		return events, nil
+	}
+
+	value, err := app.store.Get(key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal([]byte(value), &events); err != nil {
+		return nil, errors.Wrapf(err, "Failed to parse events. key: %s", key)
+	}
+
+	return events, nil
+}
+
+// DeleteEvents removes recorded events of the deployment
+func (app *Application) DeleteEvents(timestamp string) error {
+	key := eventsKey(app, timestamp)
+
+	if !app.store.HasKey(key) {
+		return nil
+	}
+
+	return app.store.Delete(key)
+}
+
+// deploymentKey returns the key which stores record of the deployment
+func deploymentKey(app *Application, timestamp string) string {
+	return "/paus/users/" + app.Username + "/apps/" + app.AppName + "/deployments/" + timestamp
 }
 
+// Deployments returns all deployments, as a map of timestamp and revision
 func (app *Application) Deployments() (map[string]string, error) {
-	var deployments = make(map[string]string)
+	records, err := app.DeploymentRecords()
+
+	if err != nil {
+		return nil, err
+	}
+
+	deployments := map[string]string{}
+
+	for timestamp, record := range records {
+		deployments[timestamp] = record.Revision
+	}
+
+	return deployments, nil
+}
+
+// DeploymentRecord returns record of the deployment registered at the timestamp
+func (app *Application) DeploymentRecord(timestamp string) (*DeploymentRecord, error) {
+	key := deploymentKey(app, timestamp)
+
+	if !app.store.HasKey(key) {
+		return nil, errors.Errorf("Deployment not found. timestamp: %s", timestamp)
+	}
+
+	value, err := app.store.Get(key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	record, err := ParseDeploymentRecord(value)
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "key: %s", key)
+	}
+
+	return record, nil
+}
+
+// DeploymentRecords returns records of all deployments, as a map of timestamp and record
+func (app *Application) DeploymentRecords() (map[string]*DeploymentRecord, error) {
+	records := map[string]*DeploymentRecord{}
 
 	deploymentsKey := "/paus/users/" + app.Username + "/apps/" + app.AppName + "/deployments/"
-	keys, err := app.etcd.List(deploymentsKey, false)
+	keys, err := app.store.List(deploymentsKey, false)
 
 	if err != nil {
 		return nil, err
 	}
 
 	for _, key := range keys {
-		value, err := app.etcd.Get(key)
+		value, err := app.store.Get(key)
 
 		if err != nil {
 			return nil, err
 		}
 
-		deployments[strings.Replace(key, deploymentsKey, "", 1)] = value
+		record, err := ParseDeploymentRecord(value)
+
+		if err != nil {
+			return nil, errors.Wrapf(err, "key: %s", key)
+		}
+
+		records[strings.Replace(key, deploymentsKey, "", 1)] = record
 	}
 
-	return deployments, nil
+	return records, nil
+}
+
+// SetDeploymentStatus updates status in the deployment record
+func (app *Application) SetDeploymentStatus(timestamp, status string) error {
+	record, err := app.DeploymentRecord(timestamp)
+
+	if err != nil {
+		return err
+	}
+
+	record.Status = status
+
+	return app.RegisterMetadata(timestamp, record)
 }
 
 func (app *Application) DirExists() bool {
-	return app.etcd.HasKey("/paus/users/" + app.Username + "/apps/" + app.AppName)
+	return app.store.HasKey("/paus/users/" + app.Username + "/apps/" + app.AppName)
 }
 
 func (app *Application) EnvironmentVariables() (map[string]string, error) {
@@ -117,30 +333,30 @@ func (app *Application) EnvironmentVariables() (map[string]string, error) {
 
 	userDirectoryKey := "/paus/users/" + app.Username
 
-	if !app.etcd.HasKey(userDirectoryKey) {
+	if !app.store.HasKey(userDirectoryKey) {
 		return map[string]string{}, nil
 	}
 
 	appDirectoryKey := userDirectoryKey + "/apps/" + app.AppName
 
-	if !app.etcd.HasKey(appDirectoryKey) {
+	if !app.store.HasKey(appDirectoryKey) {
 		return map[string]string{}, nil
 	}
 
 	envDirectoryKey := appDirectoryKey + "/envs/"
 
-	if !app.etcd.HasKey(envDirectoryKey) {
+	if !app.store.HasKey(envDirectoryKey) {
 		return map[string]string{}, nil
 	}
 
-	envKeys, err := app.etcd.List(envDirectoryKey, false)
+	envKeys, err := app.store.List(envDirectoryKey, false)
 
 	if err != nil {
 		return nil, err
 	}
 
 	for _, key := range envKeys {
-		value, err := app.etcd.Get(key)
+		value, err := app.store.Get(key)
 
 		if err != nil {
 			return nil, err
@@ -152,53 +368,213 @@ func (app *Application) EnvironmentVariables() (map[string]string, error) {
 	return envs, nil
 }
 
-func (app *Application) HealthCheck() (string, int, int, error) {
-	keyBase := "/paus/users/" + app.Username + "/apps/" + app.AppName + "/healthcheck"
+// Webhooks returns URLs of webhook endpoints of the application
+func (app *Application) Webhooks() ([]string, error) {
+	webhooks, err := app.directoryValues("webhooks")
 
-	path, err := app.etcd.Get(keyBase + "/path")
 	if err != nil {
-		return "", 0, 0, err
+		return nil, err
+	}
+
+	urls := []string{}
+
+	for _, name := range util.SortKeys(webhooks) {
+		urls = append(urls, webhooks[name])
 	}
 
-	i, err := app.etcd.Get(keyBase + "/interval")
+	return urls, nil
+}
+
+// WebhookSecret returns secret to sign webhook payloads, or empty string if it is not set
+func (app *Application) WebhookSecret() (string, error) {
+	return app.optionalValue("webhook-secret")
+}
+
+// FindDeployment finds deployment by timestamp or (prefix of) revision.
+// If the same revision was deployed several times, the latest one is returned.
+func (app *Application) FindDeployment(identifier string) (string, string, error) {
+	deployments, err := app.Deployments()
+
 	if err != nil {
-		return "", 0, 0, err
+		return "", "", err
 	}
 
-	interval, err := strconv.Atoi(i)
+	if revision, ok := deployments[identifier]; ok {
+		return identifier, revision, nil
+	}
+
+	var timestamp, revision string
+
+	for _, ts := range util.SortKeys(deployments) {
+		if !strings.HasPrefix(deployments[ts], identifier) {
+			continue
+		}
+
+		if revision != "" && revision != deployments[ts] {
+			return "", "", errors.Errorf("Revision %s is ambiguous. candidates: %s, %s", identifier, revision, deployments[ts])
+		}
+
+		timestamp, revision = ts, deployments[ts]
+	}
+
+	if timestamp == "" {
+		return "", "", errors.Errorf("Deployment not found. identifier: %s", identifier)
+	}
+
+	return timestamp, revision, nil
+}
+
+// HealthCheck returns healthcheck of the application.
+// Each setting is taken from app settings, user settings and defaults in this order.
+func (app *Application) HealthCheck(defaults map[string]string) (*HealthCheck, error) {
+	settings := map[string]string{}
+
+	for k, v := range defaults {
+		settings[k] = v
+	}
+
+	userKeyBase := "/paus/users/" + app.Username + "/healthcheck"
+	appKeyBase := "/paus/users/" + app.Username + "/apps/" + app.AppName + "/healthcheck"
+
+	for _, name := range HealthCheckSettingNames {
+		for _, key := range []string{appKeyBase + "/" + name, userKeyBase + "/" + name} {
+			if !app.store.HasKey(key) {
+				continue
+			}
+
+			value, err := app.store.Get(key)
+
+			if err != nil {
+				return nil, err
+			}
+
+			settings[name] = value
+
+			break
+		}
+	}
+
+	return NewHealthCheck(settings)
+}
+
+// CommitStatus returns settings of commit status reporting, a map of setting name (api-url, repository, token, context) and its value
+func (app *Application) CommitStatus() (map[string]string, error) {
+	return app.directoryValues("commit-status")
+}
+
+// Secrets returns secret environment variables, which are passed to web service but never saved in compose file
+func (app *Application) Secrets() (map[string]string, error) {
+	return app.directoryValues("secrets")
+}
+
+// GitCredentials returns user:password pairs per host, used to fetch submodules
+func (app *Application) GitCredentials() (map[string]string, error) {
+	return app.directoryValues("git-credentials")
+}
+
+// directoryValues returns app settings under the directory, or empty map if the directory does not exist
+func (app *Application) directoryValues(name string) (map[string]string, error) {
+	directoryKey := "/paus/users/" + app.Username + "/apps/" + app.AppName + "/" + name + "/"
+	values := map[string]string{}
+
+	if !app.store.HasKey(directoryKey) {
+		return values, nil
+	}
+
+	keys, err := app.store.List(directoryKey, false)
+
 	if err != nil {
-		return "", 0, 0, err
+		return nil, err
 	}
 
-	m, err := app.etcd.Get(keyBase + "/max-try")
+	for _, key := range keys {
+		value, err := app.store.Get(key)
+
+		if err != nil {
+			return nil, err
+		}
+
+		values[strings.Replace(key, directoryKey, "", 1)] = value
+	}
+
+	return values, nil
+}
+
+// RetentionPolicy returns retention policy of deployments, defaults overridden by app settings
+func (app *Application) RetentionPolicy(defaults *RetentionPolicy) (*RetentionPolicy, error) {
+	policy := *defaults
+
+	maxPerBranch, err := app.optionalValue("retention/max-per-branch")
+
 	if err != nil {
-		return "", 0, 0, err
+		return nil, err
 	}
 
-	maxTry, err := strconv.Atoi(m)
+	if maxPerBranch != "" {
+		if policy.MaxPerBranch, err = strconv.ParseInt(maxPerBranch, 10, 64); err != nil {
+			return nil, errors.Wrapf(err, "retention/max-per-branch must be an integer. value: %s", maxPerBranch)
+		}
+	}
+
+	maxAge, err := app.optionalValue("retention/max-age")
+
 	if err != nil {
-		return "", 0, 0, err
+		return nil, err
+	}
+
+	if maxAge != "" {
+		if policy.MaxAge, err = strconv.ParseInt(maxAge, 10, 64); err != nil {
+			return nil, errors.Wrapf(err, "retention/max-age must be an integer. value: %s", maxAge)
+		}
+	}
+
+	if app.store.HasKey("/paus/users/" + app.Username + "/apps/" + app.AppName + "/retention/protected-branches") {
+		protectedBranches, err := app.optionalValue("retention/protected-branches")
+
+		if err != nil {
+			return nil, err
+		}
+
+		policy.ProtectedBranches = ParseBranches(protectedBranches)
+	}
+
+	return &policy, nil
+}
+
+// optionalValue returns app setting, or empty string if it is not set
+func (app *Application) optionalValue(name string) (string, error) {
+	key := "/paus/users/" + app.Username + "/apps/" + app.AppName + "/" + name
+
+	if !app.store.HasKey(key) {
+		return "", nil
 	}
 
-	return path, interval, maxTry, nil
+	return app.store.Get(key)
 }
 
-func (app *Application) RegisterMetadata(revision, timestamp string) error {
+// RegisterMetadata saves the deployment record as JSON
+func (app *Application) RegisterMetadata(timestamp string, record *DeploymentRecord) error {
 	userDirectoryKey := "/paus/users/" + app.Username
 
-	if !app.etcd.HasKey(userDirectoryKey) {
-		_ = app.etcd.Mkdir(userDirectoryKey)
+	if !app.store.HasKey(userDirectoryKey) {
+		_ = app.store.Mkdir(userDirectoryKey)
 	}
 
 	appDirectoryKey := userDirectoryKey + "/apps/" + app.AppName
 
-	if !app.etcd.HasKey(appDirectoryKey) {
-		_ = app.etcd.Mkdir(appDirectoryKey)
-		_ = app.etcd.Mkdir(appDirectoryKey + "/deployments")
-		_ = app.etcd.Mkdir(appDirectoryKey + "/envs")
+	if !app.store.HasKey(appDirectoryKey) {
+		_ = app.store.Mkdir(appDirectoryKey)
+		_ = app.store.Mkdir(appDirectoryKey + "/deployments")
+		_ = app.store.Mkdir(appDirectoryKey + "/envs")
+	}
+
+	b, err := json.Marshal(record)
+
+	if err != nil {
+		return errors.Wrap(err, "Failed to generate deployment record JSON.")
 	}
 
-	if err := app.etcd.Set(appDirectoryKey+"/deployments/"+timestamp, revision); err != nil {
+	if err := app.store.Set(deploymentKey(app, timestamp), string(b)); err != nil {
 		return err
 	}
 
@@ -1,6 +1,7 @@
 package model
 
 import (
+	"reflect"
 	"testing"
 
 	"github.com/dtan4/paus-gitreceive/receiver/store"
@@ -48,3 +49,665 @@ func TestApplicationFromArgs(t *testing.T) {
 		t.Fatalf("AppName is not matched. Expected: %s, Actual: %s", expectedAppName, application.AppName)
 	}
 }
+
+func TestBuildArgs(t *testing.T) {
+	memory := store.NewMemory()
+	app := &Application{
+		Repository: "dtan4-app",
+		Username:   "dtan4",
+		AppName:    "app",
+		store:      memory,
+	}
+
+	args, err := app.BuildArgs()
+
+	if err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	if len(args) != 0 {
+		t.Fatalf("Build args should be empty when app directory does not exist. actual: %v", args)
+	}
+
+	memory.Set("/paus/users/dtan4/apps/app/build-args/FOO", "hoge")
+	memory.Set("/paus/users/dtan4/apps/app/build-args/BAR", "fuga")
+
+	args, err = app.BuildArgs()
+
+	if err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	if len(args) != 2 || args["FOO"] != "hoge" || args["BAR"] != "fuga" {
+		t.Fatalf("Build args do not match. actual: %v", args)
+	}
+}
+
+func TestHealthCheck(t *testing.T) {
+	memory := store.NewMemory()
+	app := &Application{
+		Repository: "dtan4-app",
+		Username:   "dtan4",
+		AppName:    "app",
+		store:      memory,
+	}
+
+	if _, err := app.HealthCheck(map[string]string{}); err == nil {
+		t.Fatalf("Error should be raised when healthcheck settings do not exist.")
+	}
+
+	defaults := map[string]string{
+		"interval": "2",
+		"max-try":  "30",
+		"path":     "/",
+	}
+
+	healthCheck, err := app.HealthCheck(defaults)
+
+	if err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	expected := &HealthCheck{
+		Interval: 2,
+		MaxTry:   30,
+		Mode:     "http",
+		Path:     "/",
+		Statuses: []StatusRange{{200, 200}},
+		Timeout:  5,
+	}
+
+	if !reflect.DeepEqual(healthCheck, expected) {
+		t.Fatalf("Healthcheck settings do not match. expected: %+v, actual: %+v", expected, healthCheck)
+	}
+
+	memory.Set("/paus/users/dtan4/healthcheck/path", "/health")
+	memory.Set("/paus/users/dtan4/healthcheck/interval", "5")
+	memory.Set("/paus/users/dtan4/apps/app/healthcheck/path", "/ping")
+	memory.Set("/paus/users/dtan4/apps/app/healthcheck/max-try", "10")
+	memory.Set("/paus/users/dtan4/apps/app/healthcheck/status", "2xx,3xx")
+
+	healthCheck, err = app.HealthCheck(defaults)
+
+	if err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	expected = &HealthCheck{
+		Interval: 5,
+		MaxTry:   10,
+		Mode:     "http",
+		Path:     "/ping",
+		Statuses: []StatusRange{{200, 299}, {300, 399}},
+		Timeout:  5,
+	}
+
+	if !reflect.DeepEqual(healthCheck, expected) {
+		t.Fatalf("Healthcheck settings do not match. expected: %+v, actual: %+v", expected, healthCheck)
+	}
+
+	if defaults["path"] != "/" {
+		t.Fatalf("Defaults should not be modified. path: %s", defaults["path"])
+	}
+}
+
+func TestRegisterMetadata(t *testing.T) {
+	memory := store.NewMemory()
+	app := &Application{
+		Repository: "dtan4-app",
+		Username:   "dtan4",
+		AppName:    "app",
+		store:      memory,
+	}
+
+	if app.DirExists() {
+		t.Fatalf("App directory should not exist.")
+	}
+
+	record := &DeploymentRecord{
+		Revision:    "19fb23cd71a4cf2eab00ad1a393e40de4ed61531",
+		Branch:      "master",
+		Pusher:      "dtan4",
+		Fingerprint: "4c:1f:92:b9:43:2b:23:0b:c0:e8:ab:12:cd:34:ef:56",
+		Status:      DeploymentStatusActive,
+	}
+
+	if err := app.RegisterMetadata("1467181319", record); err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	if !app.DirExists() {
+		t.Fatalf("App directory should be created.")
+	}
+
+	deployments, err := app.Deployments()
+
+	if err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	expected := "19fb23cd71a4cf2eab00ad1a393e40de4ed61531"
+
+	if deployments["1467181319"] != expected {
+		t.Fatalf("Deployment is not registered. expected: %s, actual: %s", expected, deployments["1467181319"])
+	}
+
+	actual, err := app.DeploymentRecord("1467181319")
+
+	if err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	if !reflect.DeepEqual(actual, record) {
+		t.Fatalf("Deployment record does not match. expected: %+v, actual: %+v", record, actual)
+	}
+
+	if err := app.SetDeploymentStatus("1467181319", DeploymentStatusStopped); err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	if actual, _ = app.DeploymentRecord("1467181319"); actual.Status != DeploymentStatusStopped {
+		t.Fatalf("Deployment status is not updated. expected: %s, actual: %s", DeploymentStatusStopped, actual.Status)
+	}
+
+	if err := app.DeleteDeployment("1467181319"); err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	deployments, err = app.Deployments()
+
+	if err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	if len(deployments) != 0 {
+		t.Fatalf("Deployment is not deleted. actual: %v", deployments)
+	}
+}
+
+func TestApplicationFromRepository(t *testing.T) {
+	memory := store.NewMemory()
+
+	if _, err := ApplicationFromRepository("rails-sample", memory); err == nil {
+		t.Fatalf("Error should be raised when repository does not contain username.")
+	}
+
+	application, err := ApplicationFromRepository("dtan4/rails-sample", memory)
+
+	if err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	if application.Repository != "dtan4-rails-sample" {
+		t.Fatalf("Repository is not matched. Expected: %s, Actual: %s", "dtan4-rails-sample", application.Repository)
+	}
+
+	if application.Username != "dtan4" {
+		t.Fatalf("Username is not matched. Expected: %s, Actual: %s", "dtan4", application.Username)
+	}
+
+	if application.AppName != "rails-sample" {
+		t.Fatalf("AppName is not matched. Expected: %s, Actual: %s", "rails-sample", application.AppName)
+	}
+}
+
+func TestFindDeployment(t *testing.T) {
+	memory := store.NewMemory()
+	app := &Application{
+		Repository: "dtan4-app",
+		Username:   "dtan4",
+		AppName:    "app",
+		store:      memory,
+	}
+
+	memory.Set("/paus/users/dtan4/apps/app/deployments/1467181319", "19fb23cd71a4cf2eab00ad1a393e40de4ed61531")
+	memory.Set("/paus/users/dtan4/apps/app/deployments/1467181320", "3e634e41d5a819a7586c621a6322ee4d5085232c")
+	memory.Set("/paus/users/dtan4/apps/app/deployments/1467181321", "19fb23cd71a4cf2eab00ad1a393e40de4ed61531")
+	memory.Set("/paus/users/dtan4/apps/app/deployments/1467181322", "3e6aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")
+
+	testcases := []struct {
+		identifier string
+		timestamp  string
+		revision   string
+	}{
+		{"1467181320", "1467181320", "3e634e41d5a819a7586c621a6322ee4d5085232c"},
+		{"3e634e41", "1467181320", "3e634e41d5a819a7586c621a6322ee4d5085232c"},
+		{"19fb23cd", "1467181321", "19fb23cd71a4cf2eab00ad1a393e40de4ed61531"},
+	}
+
+	for _, tc := range testcases {
+		timestamp, revision, err := app.FindDeployment(tc.identifier)
+
+		if err != nil {
+			t.Fatalf("Unexpected error has been raised. identifier: %s, error: %s", tc.identifier, err)
+		}
+
+		if timestamp != tc.timestamp || revision != tc.revision {
+			t.Fatalf("Deployment does not match. identifier: %s, expected: %s/%s, actual: %s/%s", tc.identifier, tc.timestamp, tc.revision, timestamp, revision)
+		}
+	}
+
+	if _, _, err := app.FindDeployment("3e6"); err == nil {
+		t.Fatalf("Error should be raised when revision is ambiguous.")
+	}
+
+	if _, _, err := app.FindDeployment("deadbeef"); err == nil {
+		t.Fatalf("Error should be raised when deployment does not exist.")
+	}
+}
+
+func TestWebServiceAndPort(t *testing.T) {
+	memory := store.NewMemory()
+	app := &Application{
+		Repository: "dtan4-app",
+		Username:   "dtan4",
+		AppName:    "app",
+		store:      memory,
+	}
+
+	webService, err := app.WebService()
+
+	if err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	if webService != "" {
+		t.Fatalf("WebService should be empty when it is not set. actual: %s", webService)
+	}
+
+	memory.Set("/paus/users/dtan4/apps/app/web-service", "api")
+	memory.Set("/paus/users/dtan4/apps/app/web-port", "3000")
+
+	webService, err = app.WebService()
+
+	if err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	if webService != "api" {
+		t.Fatalf("WebService does not match. expected: api, actual: %s", webService)
+	}
+
+	webPort, err := app.WebPort()
+
+	if err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	if webPort != "3000" {
+		t.Fatalf("WebPort does not match. expected: 3000, actual: %s", webPort)
+	}
+}
+
+func TestScale(t *testing.T) {
+	memory := store.NewMemory()
+	app := &Application{
+		Repository: "dtan4-app",
+		Username:   "dtan4",
+		AppName:    "app",
+		store:      memory,
+	}
+
+	scale, err := app.Scale()
+
+	if err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	if scale != 1 {
+		t.Fatalf("Scale should be 1 when it is not set. actual: %d", scale)
+	}
+
+	memory.Set("/paus/users/dtan4/apps/app/scale", "3")
+
+	scale, err = app.Scale()
+
+	if err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	if scale != 3 {
+		t.Fatalf("Scale does not match. expected: 3, actual: %d", scale)
+	}
+
+	for _, value := range []string{"0", "-1", "three"} {
+		memory.Set("/paus/users/dtan4/apps/app/scale", value)
+
+		if _, err := app.Scale(); err == nil {
+			t.Fatalf("Error should be raised with invalid scale. scale: %s", value)
+		}
+	}
+}
+
+func TestWebhooks(t *testing.T) {
+	memory := store.NewMemory()
+	app := &Application{
+		Repository: "dtan4-app",
+		Username:   "dtan4",
+		AppName:    "app",
+		store:      memory,
+	}
+
+	urls, err := app.Webhooks()
+
+	if err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	if len(urls) != 0 {
+		t.Fatalf("Webhooks should be empty when they are not set. actual: %v", urls)
+	}
+
+	memory.Set("/paus/users/dtan4/apps/app/webhooks/chat", "https://chat.example.com/hooks/paus")
+	memory.Set("/paus/users/dtan4/apps/app/webhooks/ci", "https://ci.example.com/paus")
+	memory.Set("/paus/users/dtan4/apps/app/webhook-secret", "secret")
+
+	expected := []string{"https://chat.example.com/hooks/paus", "https://ci.example.com/paus"}
+	urls, err = app.Webhooks()
+
+	if err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	if !reflect.DeepEqual(urls, expected) {
+		t.Fatalf("Webhooks do not match. expected: %v, actual: %v", expected, urls)
+	}
+
+	secret, err := app.WebhookSecret()
+
+	if err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	if secret != "secret" {
+		t.Fatalf("WebhookSecret does not match. expected: secret, actual: %s", secret)
+	}
+}
+
+func TestCommitStatus(t *testing.T) {
+	memory := store.NewMemory()
+	app := &Application{
+		Repository: "dtan4-app",
+		Username:   "dtan4",
+		AppName:    "app",
+		store:      memory,
+	}
+
+	settings, err := app.CommitStatus()
+
+	if err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	if len(settings) != 0 {
+		t.Fatalf("CommitStatus should be empty when it is not set. actual: %v", settings)
+	}
+
+	memory.Set("/paus/users/dtan4/apps/app/commit-status/repository", "dtan4/app")
+	memory.Set("/paus/users/dtan4/apps/app/commit-status/token", "token")
+
+	expected := map[string]string{"repository": "dtan4/app", "token": "token"}
+	settings, err = app.CommitStatus()
+
+	if err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	if !reflect.DeepEqual(settings, expected) {
+		t.Fatalf("CommitStatus does not match. expected: %v, actual: %v", expected, settings)
+	}
+}
+
+func TestBranchDeployments(t *testing.T) {
+	memory := store.NewMemory()
+	app := &Application{
+		Repository: "dtan4-app",
+		Username:   "dtan4",
+		AppName:    "app",
+		store:      memory,
+	}
+
+	for _, d := range []*Deployment{
+		NewDeployment(app, "feature/login", "19fb23cd71a4cf2eab00ad1a393e40de4ed61531", "1467181319", "/repos"),
+		NewDeployment(app, "feature/login", "3e634e41d5a819a7586c621a6322ee4d5085232c", "1467181320", "/repos"),
+		NewDeployment(app, "master", "3e634e41d5a819a7586c621a6322ee4d5085232c", "1467181321", "/repos"),
+	} {
+		if err := d.Register(); err != nil {
+			t.Fatalf("Unexpected error has been raised. error: %s", err)
+		}
+	}
+
+	expected := map[string]string{
+		"1467181319": "19fb23cd71a4cf2eab00ad1a393e40de4ed61531",
+		"1467181320": "3e634e41d5a819a7586c621a6322ee4d5085232c",
+	}
+	deployments, err := app.BranchDeployments("feature/login")
+
+	if err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	if !reflect.DeepEqual(deployments, expected) {
+		t.Fatalf("Deployments do not match. expected: %v, actual: %v", expected, deployments)
+	}
+
+	if err := app.DeleteDeployment("1467181319"); err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	deployments, _ = app.BranchDeployments("feature/login")
+
+	if _, ok := deployments["1467181319"]; ok {
+		t.Fatalf("Deleted deployment should not be included. actual: %v", deployments)
+	}
+
+	deployments, _ = app.BranchDeployments("master")
+
+	if len(deployments) != 1 {
+		t.Fatalf("Deployments of other branches should not be affected. actual: %v", deployments)
+	}
+
+	memory.Set("/paus/users/dtan4/apps/app/deployments/1467181318", "19fb23cd71a4cf2eab00ad1a393e40de4ed61531")
+
+	deployments, _ = app.BranchDeployments("")
+
+	if len(deployments) != 1 {
+		t.Fatalf("Deployments of old format should have empty branch. actual: %v", deployments)
+	}
+}
+
+func TestRetentionPolicy(t *testing.T) {
+	memory := store.NewMemory()
+	app := &Application{
+		Repository: "dtan4-app",
+		Username:   "dtan4",
+		AppName:    "app",
+		store:      memory,
+	}
+
+	defaults := &RetentionPolicy{
+		MaxPerBranch:      10,
+		ProtectedBranches: []string{"master"},
+	}
+
+	policy, err := app.RetentionPolicy(defaults)
+
+	if err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	if !reflect.DeepEqual(policy, defaults) {
+		t.Fatalf("Policy should be defaults when app settings are not set. expected: %+v, actual: %+v", defaults, policy)
+	}
+
+	memory.Set("/paus/users/dtan4/apps/app/retention/max-per-branch", "3")
+	memory.Set("/paus/users/dtan4/apps/app/retention/max-age", "86400")
+	memory.Set("/paus/users/dtan4/apps/app/retention/protected-branches", "master,production")
+
+	expected := &RetentionPolicy{
+		MaxAge:            86400,
+		MaxPerBranch:      3,
+		ProtectedBranches: []string{"master", "production"},
+	}
+	policy, err = app.RetentionPolicy(defaults)
+
+	if err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	if !reflect.DeepEqual(policy, expected) {
+		t.Fatalf("Policy does not match. expected: %+v, actual: %+v", expected, policy)
+	}
+
+	if defaults.MaxPerBranch != 10 {
+		t.Fatalf("Defaults should not be modified. actual: %+v", defaults)
+	}
+
+	memory.Set("/paus/users/dtan4/apps/app/retention/max-age", "one day")
+
+	if _, err := app.RetentionPolicy(defaults); err == nil {
+		t.Fatalf("Error should be raised with invalid max-age.")
+	}
+}
+
+func TestDeploymentRecordsOldFormat(t *testing.T) {
+	memory := store.NewMemory()
+	app := &Application{
+		Repository: "dtan4-app",
+		Username:   "dtan4",
+		AppName:    "app",
+		store:      memory,
+	}
+
+	memory.Set("/paus/users/dtan4/apps/app/deployments/1467181319", "19fb23cd71a4cf2eab00ad1a393e40de4ed61531")
+	memory.Set("/paus/users/dtan4/apps/app/deployments/1467181320", "3e634e41d5a819a7586c621a6322ee4d5085232c")
+	memory.Set("/paus/users/dtan4/apps/app/deployments/1467181321", `{"revision":"3e634e41d5a819a7586c621a6322ee4d5085232c","branch":"master","pusher":"dtan4","status":"active"}`)
+
+	expected := map[string]*DeploymentRecord{
+		"1467181319": &DeploymentRecord{Revision: "19fb23cd71a4cf2eab00ad1a393e40de4ed61531"},
+		"1467181320": &DeploymentRecord{Revision: "3e634e41d5a819a7586c621a6322ee4d5085232c"},
+		"1467181321": &DeploymentRecord{Revision: "3e634e41d5a819a7586c621a6322ee4d5085232c", Branch: "master", Pusher: "dtan4", Status: DeploymentStatusActive},
+	}
+	actual, err := app.DeploymentRecords()
+
+	if err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("Deployment records do not match. expected: %+v, actual: %+v", expected, actual)
+	}
+
+	record, err := app.DeploymentRecord("1467181320")
+
+	if err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	if !reflect.DeepEqual(record, expected["1467181320"]) {
+		t.Fatalf("Deployment record does not match. expected: %+v, actual: %+v", expected["1467181320"], record)
+	}
+
+	if _, err := app.DeploymentRecord("1467181322"); err == nil {
+		t.Fatalf("Error should be raised when deployment does not exist.")
+	}
+}
+
+func TestApplicationHookCommand(t *testing.T) {
+	memory := store.NewMemory()
+	app := &Application{
+		Repository: "dtan4-app",
+		Username:   "dtan4",
+		AppName:    "app",
+		store:      memory,
+	}
+
+	command, err := app.HookCommand(HookPreDeploy)
+
+	if err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	if command != "" {
+		t.Fatalf("Hook command should be empty when it is not set. actual: %s", command)
+	}
+
+	memory.Set("/paus/users/dtan4/apps/app/hooks/pre-deploy", "bin/rake db:migrate")
+
+	command, err = app.HookCommand(HookPreDeploy)
+
+	if err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	if command != "bin/rake db:migrate" {
+		t.Fatalf("Hook command does not match. expected: %s, actual: %s", "bin/rake db:migrate", command)
+	}
+}
+
+func TestIncrementalBuild(t *testing.T) {
+	memory := store.NewMemory()
+	app := &Application{
+		Repository: "dtan4-app",
+		Username:   "dtan4",
+		AppName:    "app",
+		store:      memory,
+	}
+
+	incremental, err := app.IncrementalBuild()
+
+	if err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	if incremental {
+		t.Fatalf("Incremental build should be disabled when it is not set.")
+	}
+
+	memory.Set("/paus/users/dtan4/apps/app/build/incremental", "true")
+
+	incremental, err = app.IncrementalBuild()
+
+	if err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	if !incremental {
+		t.Fatalf("Incremental build should be enabled.")
+	}
+
+	memory.Set("/paus/users/dtan4/apps/app/build/incremental", "yes please")
+
+	if _, err := app.IncrementalBuild(); err == nil {
+		t.Fatalf("Error should be raised with invalid value.")
+	}
+
+	expected := "/repos/dtan4/.worktree/dtan4-app"
+
+	if actual := app.WorkingCopyPath("/repos"); actual != expected {
+		t.Fatalf("WorkingCopyPath does not match. expected: %s, actual: %s", expected, actual)
+	}
+}
+
+func TestGitCredentials(t *testing.T) {
+	memory := store.NewMemory()
+	app := &Application{
+		Repository: "dtan4-app",
+		Username:   "dtan4",
+		AppName:    "app",
+		store:      memory,
+	}
+
+	memory.Set("/paus/users/dtan4/apps/app/git-credentials/github.com", "dtan4:token")
+
+	expected := map[string]string{"github.com": "dtan4:token"}
+	credentials, err := app.GitCredentials()
+
+	if err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	if !reflect.DeepEqual(credentials, expected) {
+		t.Fatalf("GitCredentials does not match. expected: %v, actual: %v", expected, credentials)
+	}
+}
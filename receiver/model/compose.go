@@ -1,49 +1,162 @@
 package model
 
-// TODO: Use github.com/docker/libcompose
-
 import (
+	"bytes"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
-	"os/exec"
 	"regexp"
+	"sort"
 	"strings"
 
 	"github.com/docker/libcompose/config"
+	"github.com/docker/libcompose/docker"
+	"github.com/docker/libcompose/docker/client"
 	"github.com/docker/libcompose/lookup"
 	"github.com/docker/libcompose/project"
+	"github.com/docker/libcompose/project/events"
+	"github.com/docker/libcompose/project/options"
 	"github.com/dtan4/paus-gitreceive/receiver/util"
 	"github.com/pkg/errors"
+	"golang.org/x/net/context"
 	"gopkg.in/yaml.v2"
 )
 
 const (
+	defaultWebService = "web"
+	extensionPrefix   = "x-"
+	healthCheckKey    = "x-paus-healthcheck"
+	hookLabelPrefix   = "paus.hook."
 	portBindingRegexp = `"?\d+:(\d+)"?`
+	portLabel         = "paus.port"
+	routeLabel        = "paus.route"
+	routeNameRegexp   = `^[a-z0-9]([a-z0-9-]*[a-z0-9])?$`
+	stopTimeout       = 10
+	webLabel          = "paus.web"
 )
 
 var (
 	portBinding = regexp.MustCompile(portBindingRegexp)
+	routeName   = regexp.MustCompile(routeNameRegexp)
 )
 
 type Compose struct {
-	ComposeFilePath string
-	ProjectName     string
+	ComposeFilePath     string
+	HealthCheckSettings map[string]string
+	ProjectName         string
+	WebService          string
 
 	dockerHost string
 	project    *project.Project
+	secrets    map[string]string
+}
+
+// ComposeError is raised when operation against Docker through libcompose fails
+type ComposeError struct {
+	Operation   string
+	ProjectName string
+	Services    []string
+	Err         error
+}
+
+func (e *ComposeError) Error() string {
+	return fmt.Sprintf("Failed to %s. projectName: %s, services: %v: %s", e.Operation, e.ProjectName, e.Services, e.Err)
+}
+
+// Cause returns the original error, for github.com/pkg/errors.Cause
+func (e *ComposeError) Cause() error {
+	return e.Err
 }
 
 type ComposeConfig struct {
-	Version  string                           `yaml:"version,omitempty"`
-	Services map[string]*config.ServiceConfig `yaml:"services,omitempty"`
-	Volumes  map[string]*config.VolumeConfig  `yaml:"volumes,omitempty"`
-	Networks map[string]*config.NetworkConfig `yaml:"networks,omitempty"`
+	Version     string                           `yaml:"version,omitempty"`
+	Services    map[string]*config.ServiceConfig `yaml:"services,omitempty"`
+	Volumes     map[string]*config.VolumeConfig  `yaml:"volumes,omitempty"`
+	Networks    map[string]*config.NetworkConfig `yaml:"networks,omitempty"`
+	HealthCheck map[string]string                `yaml:"x-paus-healthcheck,omitempty"`
+}
+
+// readComposeFile reads docker-compose.yml and extracts x-paus-healthcheck section.
+// Top-level extension fields (x-*) are removed from returned content, because libcompose does not accept them.
+func readComposeFile(composeFilePath string) ([]byte, map[string]string, error) {
+	content, err := ioutil.ReadFile(composeFilePath)
+
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "Failed to read docker-compose.yml. path: %s", composeFilePath)
+	}
+
+	var cfg map[string]interface{}
+
+	if err := yaml.Unmarshal(content, &cfg); err != nil {
+		return nil, nil, errors.Wrapf(err, "Failed to parse docker-compose.yml. path: %s", composeFilePath)
+	}
+
+	settings := map[string]string{}
+	extended := false
+
+	for key, value := range cfg {
+		if !strings.HasPrefix(key, extensionPrefix) {
+			continue
+		}
+
+		extended = true
+		delete(cfg, key)
+
+		if key != healthCheckKey {
+			continue
+		}
+
+		section, ok := value.(map[interface{}]interface{})
+
+		if !ok {
+			return nil, nil, errors.Errorf("%s must be a mapping. path: %s", healthCheckKey, composeFilePath)
+		}
+
+		for k, v := range section {
+			name := fmt.Sprint(k)
+
+			if !validHealthCheckSetting(name) {
+				return nil, nil, errors.Errorf("Unknown healthcheck setting in %s. name: %s", healthCheckKey, name)
+			}
+
+			settings[name] = fmt.Sprint(v)
+		}
+	}
+
+	if !extended {
+		return content, settings, nil
+	}
+
+	content, err = yaml.Marshal(cfg)
+
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "Failed to generate YAML file.")
+	}
+
+	return content, settings, nil
+}
+
+func validHealthCheckSetting(name string) bool {
+	for _, n := range HealthCheckSettingNames {
+		if n == name {
+			return true
+		}
+	}
+
+	return false
 }
 
 func NewCompose(dockerHost, composeFilePath, projectName string) (*Compose, error) {
+	content, healthCheckSettings, err := readComposeFile(composeFilePath)
+
+	if err != nil {
+		return nil, err
+	}
+
 	ctx := project.Context{
 		ComposeFiles: []string{composeFilePath},
+		ComposeBytes: [][]byte{content},
 		ProjectName:  projectName,
 	}
 
@@ -54,41 +167,151 @@ func NewCompose(dockerHost, composeFilePath, projectName string) (*Compose, erro
 		},
 	}
 
-	prj := project.NewProject(&ctx, nil, nil)
+	clientFactory, err := client.NewDefaultFactory(client.Options{
+		Host: dockerHost,
+	})
 
-	if err := prj.Parse(); err != nil {
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to create Docker client. dockerHost: %s", dockerHost)
+	}
+
+	apiProject, err := docker.NewProject(&docker.Context{
+		Context:       ctx,
+		ClientFactory: clientFactory,
+	}, nil)
+
+	if err != nil {
 		return nil, errors.Wrap(err, "Failed to parse docker-compose.yml.")
 	}
 
+	prj, ok := apiProject.(*project.Project)
+
+	if !ok {
+		return nil, errors.Errorf("Unexpected project type. type: %T", apiProject)
+	}
+
+	webService, err := webServiceFromLabels(prj)
+
+	if err != nil {
+		return nil, err
+	}
+
+	listener := make(chan events.Event)
+	prj.AddListener(listener)
+
+	go printEvents(listener)
+
 	return &Compose{
-		ComposeFilePath: composeFilePath,
-		ProjectName:     projectName,
-		dockerHost:      dockerHost,
-		project:         prj,
+		ComposeFilePath:     composeFilePath,
+		HealthCheckSettings: healthCheckSettings,
+		ProjectName:         projectName,
+		WebService:          webService,
+		dockerHost:          dockerHost,
+		project:             prj,
 	}, nil
 }
 
-func (c *Compose) Build() error {
-	cmd := exec.Command("docker-compose", "-f", c.ComposeFilePath, "-p", c.ProjectName, "build")
-	cmd.Env = append(os.Environ(), "DOCKER_HOST="+c.dockerHost)
+// HookCommand returns command of the hook labeled on web service as paus.hook.<hook>, or empty string if it is not set
+func (c *Compose) HookCommand(hook string) string {
+	svc := c.webService()
 
-	if err := util.RunCommand(cmd); err != nil {
-		return err
+	if svc == nil {
+		return ""
+	}
+
+	return svc.Labels[hookLabelPrefix+hook]
+}
+
+// RunOneOff runs command in a one-off container of the service, and returns its exit code
+func (c *Compose) RunOneOff(service string, command []string, output io.Writer) (int, error) {
+	containerID, err := c.GetContainerID(service)
+
+	if err != nil {
+		return 0, err
+	}
+
+	exitCode, err := RunOneOff(c.dockerHost, containerID, command, output)
+
+	if err != nil {
+		return 0, c.newError("run one-off container", []string{service}, err)
+	}
+
+	return exitCode, nil
+}
+
+// webServiceFromLabels returns the service labeled as paus.web=true, or "web" if there is no such service.
+// Multiple labeled services are rejected, because the web service must be chosen explicitly.
+func webServiceFromLabels(prj *project.Project) (string, error) {
+	services := []string{}
+
+	for _, key := range prj.ServiceConfigs.Keys() {
+		if svc, ok := prj.ServiceConfigs.Get(key); ok && svc.Labels[webLabel] == "true" {
+			services = append(services, key)
+		}
+	}
+
+	switch len(services) {
+	case 0:
+		return defaultWebService, nil
+	case 1:
+		return services[0], nil
+	}
+
+	sort.Strings(services)
+
+	return "", errors.Errorf("Only one service can be labeled as %s=true. services: %s", webLabel, strings.Join(services, ", "))
+}
+
+func printEvents(listener <-chan events.Event) {
+	for event := range listener {
+		if event.EventType == events.NoEvent || event.ServiceName == "" {
+			continue
+		}
+
+		fmt.Println("       " + event.ServiceName + ": " + event.EventType.String())
+	}
+}
+
+func (c *Compose) newError(operation string, services []string, err error) error {
+	return &ComposeError{
+		Operation:   operation,
+		ProjectName: c.ProjectName,
+		Services:    services,
+		Err:         err,
+	}
+}
+
+func (c *Compose) Build() error {
+	if err := c.project.Build(context.Background(), options.Build{}); err != nil {
+		return c.newError("build images", nil, err)
 	}
 
 	return nil
 }
 
 func (c *Compose) GetContainerID(service string) (string, error) {
-	cmd := exec.Command("docker-compose", "-f", c.ComposeFilePath, "-p", c.ProjectName, "ps", "-q", service)
-	cmd.Env = append(os.Environ(), "DOCKER_HOST="+c.dockerHost)
-	out, err := cmd.Output()
+	containerIDs, err := c.GetContainerIDs(service)
+
+	if err != nil {
+		return "", err
+	}
+
+	return containerIDs[0], nil
+}
+
+// GetContainerIDs returns IDs of running containers of the service. Exited containers are excluded.
+func (c *Compose) GetContainerIDs(service string) ([]string, error) {
+	containerIDs, err := c.project.Containers(context.Background(), project.Filter{State: project.Running}, service)
 
 	if err != nil {
-		return "", errors.Wrapf(err, "Failed to get container ID. projectName: %s, service: %s", c.ProjectName, service)
+		return nil, c.newError("get container ID", []string{service}, err)
+	}
+
+	if len(containerIDs) == 0 {
+		return nil, c.newError("get container ID", []string{service}, errors.New("No running container found"))
 	}
 
-	return strings.Replace(string(out), "\n", "", -1), nil
+	return containerIDs, nil
 }
 
 func (c *Compose) InjectBuildArgs(buildArgs map[string]string) {
@@ -135,14 +358,103 @@ func (c *Compose) InjectEnvironmentVariables(envs map[string]string) {
 	}
 }
 
-func (c *Compose) Pull() error {
-	cmd := exec.Command("docker-compose", "-f", c.ComposeFilePath, "-p", c.ProjectName, "pull")
-	cmd.Env = append(os.Environ(), "DOCKER_HOST="+c.dockerHost)
+// InjectSecrets passes secrets to web service through env_file.
+// The env file is created with 0600 permission outside the repository, and removed as soon as it is loaded.
+// Secrets are kept only in memory, and removed from the file saved by SaveAs.
+func (c *Compose) InjectSecrets(secrets map[string]string) error {
+	webService := c.webService()
 
-	if err := util.RunCommand(cmd); err != nil {
+	if webService == nil || len(secrets) == 0 {
+		return nil
+	}
+
+	envFilePath, err := writeEnvFile(secrets)
+
+	if err != nil {
 		return err
 	}
 
+	defer os.Remove(envFilePath)
+
+	c.secrets = secrets
+
+	// environment takes precedence over env_file, so that secrets must not be left there
+	webService.Environment = c.withoutSecrets(webService).Environment
+
+	override, err := yaml.Marshal(map[string]interface{}{
+		"version": "2",
+		"services": map[string]interface{}{
+			c.WebService: map[string]interface{}{
+				"env_file": []string{envFilePath},
+			},
+		},
+	})
+
+	if err != nil {
+		return errors.Wrap(err, "Failed to generate YAML file.")
+	}
+
+	if err := c.project.Load(override); err != nil {
+		return c.newError("load secrets", []string{c.WebService}, err)
+	}
+
+	return nil
+}
+
+// writeEnvFile writes secrets to a temporary env file, which is readable only by the owner
+func writeEnvFile(secrets map[string]string) (string, error) {
+	var content bytes.Buffer
+
+	for _, name := range util.SortKeys(secrets) {
+		// env_file cannot hold multi-line value
+		if strings.ContainsAny(secrets[name], "\r\n") {
+			return "", errors.Errorf("Secret must not contain newline. name: %s", name)
+		}
+
+		content.WriteString(name + "=" + secrets[name] + "\n")
+	}
+
+	// ioutil.TempFile creates file with 0600 permission
+	fp, err := ioutil.TempFile("", "paus-secrets-")
+
+	if err != nil {
+		return "", errors.Wrap(err, "Failed to create env file for secrets.")
+	}
+
+	defer fp.Close()
+
+	if _, err := fp.Write(content.Bytes()); err != nil {
+		os.Remove(fp.Name())
+		return "", errors.Wrapf(err, "Failed to write env file for secrets. path: %s", fp.Name())
+	}
+
+	return fp.Name(), nil
+}
+
+// Secrets returns secrets injected to web service
+func (c *Compose) Secrets() map[string]string {
+	return c.secrets
+}
+
+// withoutSecrets returns copy of the service config whose environment variables do not contain secrets
+func (c *Compose) withoutSecrets(svc *config.ServiceConfig) *config.ServiceConfig {
+	copied := *svc
+	copied.Environment = []string{}
+
+	for _, env := range svc.Environment {
+		if _, ok := c.secrets[strings.SplitN(env, "=", 2)[0]]; !ok {
+			copied.Environment = append(copied.Environment, env)
+		}
+	}
+
+	return &copied
+}
+
+func (c *Compose) Pull() error {
+	if err := c.project.Pull(context.Background()); err != nil {
+		return c.newError("pull images", nil, err)
+	}
+
 	return nil
 }
 
@@ -177,15 +489,20 @@ func (c *Compose) SaveAs(filePath string) error {
 
 	for _, key := range c.project.ServiceConfigs.Keys() {
 		if svc, ok := c.project.ServiceConfigs.Get(key); ok {
+			if key == c.WebService && len(c.secrets) > 0 {
+				svc = c.withoutSecrets(svc)
+			}
+
 			services[key] = svc
 		}
 	}
 
 	cfg := &ComposeConfig{
-		Version:  "2",
-		Services: services,
-		Volumes:  c.project.VolumeConfigs,
-		Networks: c.project.NetworkConfigs,
+		Version:     "2",
+		Services:    services,
+		Volumes:     c.project.VolumeConfigs,
+		Networks:    c.project.NetworkConfigs,
+		HealthCheck: c.HealthCheckSettings,
 	}
 
 	data, err := yaml.Marshal(cfg)
@@ -203,30 +520,85 @@ func (c *Compose) SaveAs(filePath string) error {
 	return nil
 }
 
-func (c *Compose) Stop() error {
-	cmd := exec.Command("docker-compose", "-f", c.ComposeFilePath, "-p", c.ProjectName, "stop")
-	cmd.Env = append(os.Environ(), "DOCKER_HOST="+c.dockerHost)
+// Scale runs the given number of containers of the service
+func (c *Compose) Scale(service string, scale int) error {
+	if err := c.project.Scale(context.Background(), stopTimeout, map[string]int{service: scale}); err != nil {
+		return c.newError("scale containers", []string{service}, err)
+	}
 
-	if err := util.RunCommand(cmd); err != nil {
-		return err
+	return nil
+}
+
+func (c *Compose) Stop() error {
+	if err := c.project.Stop(context.Background(), stopTimeout); err != nil {
+		return c.newError("stop containers", nil, err)
 	}
 
 	return nil
 }
 
 func (c *Compose) Up() error {
-	cmd := exec.Command("docker-compose", "-f", c.ComposeFilePath, "-p", c.ProjectName, "up", "-d")
-	cmd.Env = append(os.Environ(), "DOCKER_HOST="+c.dockerHost)
-
-	if err := util.RunCommand(cmd); err != nil {
-		return err
+	if err := c.project.Up(context.Background(), options.Up{}); err != nil {
+		return c.newError("start containers", nil, err)
 	}
 
 	return nil
 }
 
+// RoutedServices returns services labeled as paus.route=<name>, as a map of route name and service name
+func (c *Compose) RoutedServices() (map[string]string, error) {
+	routes := map[string]string{}
+
+	for _, key := range c.project.ServiceConfigs.Keys() {
+		svc, ok := c.project.ServiceConfigs.Get(key)
+
+		if !ok || key == c.WebService {
+			continue
+		}
+
+		route, ok := svc.Labels[routeLabel]
+
+		if !ok {
+			continue
+		}
+
+		if !routeName.MatchString(route) {
+			return nil, errors.Errorf("Route name must consist of lower case alphanumeric characters or '-'. service: %s, route: %s", key, route)
+		}
+
+		if service, ok := routes[route]; ok {
+			return nil, errors.Errorf("Route %s is used by several services. services: %s, %s", route, service, key)
+		}
+
+		routes[route] = key
+	}
+
+	return routes, nil
+}
+
+// HasService returns whether the service is defined in docker-compose.yml
+func (c *Compose) HasService(service string) bool {
+	_, ok := c.project.ServiceConfigs.Get(service)
+
+	return ok
+}
+
+// ServicePort returns container port of the service specified by paus.port label, or empty string if it is not set
+func (c *Compose) ServicePort(service string) string {
+	if svc, ok := c.project.ServiceConfigs.Get(service); ok {
+		return svc.Labels[portLabel]
+	}
+
+	return ""
+}
+
+// WebPort returns container port of web service specified by paus.port label, or empty string if it is not set
+func (c *Compose) WebPort() string {
+	return c.ServicePort(c.WebService)
+}
+
 func (c *Compose) webService() *config.ServiceConfig {
-	if svc, ok := c.project.ServiceConfigs.Get("web"); ok {
+	if svc, ok := c.project.ServiceConfigs.Get(c.WebService); ok {
 		return svc
 	}
 
@@ -2,11 +2,15 @@ package model
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/docker/libcompose/config"
+	"github.com/pkg/errors"
 )
 
 const (
@@ -15,8 +19,8 @@ const (
 )
 
 var (
-	v1FilePath, v2FilePath, v2FilePathBuildArg, v2FilePathNoBuildEnv string
-	v1Compose, v2Compose, v2ComposeBuildArg, v2ComposeNoBuildEnv     *Compose
+	v1FilePath, v2FilePath, v2FilePathBuildArg, v2FilePathNoBuildEnv, v2FilePathLabels string
+	v1Compose, v2Compose, v2ComposeBuildArg, v2ComposeNoBuildEnv, v2ComposeLabels      *Compose
 )
 
 func contains(slice []string, item string) bool {
@@ -48,11 +52,13 @@ func setup() {
 	v2FilePath = fixturePath("docker-compose-v2.yml")
 	v2FilePathBuildArg = fixturePath("docker-compose-v2-buildarg.yml")
 	v2FilePathNoBuildEnv = fixturePath("docker-compose-v2-nobuildenv.yml")
+	v2FilePathLabels = fixturePath("docker-compose-v2-labels.yml")
 
 	v1Compose, _ = NewCompose(dockerHost, v1FilePath, projectName)
 	v2Compose, _ = NewCompose(dockerHost, v2FilePath, projectName)
 	v2ComposeBuildArg, _ = NewCompose(dockerHost, v2FilePathBuildArg, projectName)
 	v2ComposeNoBuildEnv, _ = NewCompose(dockerHost, v2FilePathNoBuildEnv, projectName)
+	v2ComposeLabels, _ = NewCompose(dockerHost, v2FilePathLabels, projectName)
 }
 
 func TestInjectBuildArgs(t *testing.T) {
@@ -223,3 +229,260 @@ func TestSaveAs(t *testing.T) {
 
 	os.Remove(newFilePath)
 }
+
+func TestSaveAsWithoutSecrets(t *testing.T) {
+	setup()
+
+	v2Compose.InjectEnvironmentVariables(map[string]string{"FOO": "hoge", "SECRET_KEY_BASE": "plain"})
+
+	if err := v2Compose.InjectSecrets(map[string]string{"SECRET_KEY_BASE": "s3cr3t"}); err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	svc, _ := v2Compose.project.ServiceConfigs.Get("web")
+
+	if !contains(svc.Environment, "SECRET_KEY_BASE=s3cr3t") || contains(svc.Environment, "SECRET_KEY_BASE=plain") {
+		t.Fatalf("Secrets should be passed to web service in place of environment variables. environments: %v", svc.Environment)
+	}
+
+	newFilePath := filepath.Join("/tmp", "new-docker-compose-secrets.yml")
+	defer os.Remove(newFilePath)
+
+	if err := v2Compose.SaveAs(newFilePath); err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	content, err := ioutil.ReadFile(newFilePath)
+
+	if err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	if strings.Contains(string(content), "s3cr3t") || strings.Contains(string(content), "SECRET_KEY_BASE") {
+		t.Fatalf("Secrets should not be saved in compose file. content: %s", content)
+	}
+
+	if !strings.Contains(string(content), "FOO=hoge") {
+		t.Fatalf("Environment variables should be saved in compose file. content: %s", content)
+	}
+
+	if !contains(svc.Environment, "SECRET_KEY_BASE=s3cr3t") {
+		t.Fatalf("Secrets should be kept in memory after saving. environments: %v", svc.Environment)
+	}
+}
+
+func TestInjectSecretsWithNewline(t *testing.T) {
+	setup()
+
+	if err := v2Compose.InjectSecrets(map[string]string{"PRIVATE_KEY": "line1\nline2"}); err == nil {
+		t.Fatalf("Error should be raised when secret contains newline.")
+	}
+}
+
+func TestWriteEnvFile(t *testing.T) {
+	envFilePath, err := writeEnvFile(map[string]string{"SECRET_KEY_BASE": "s3cr3t", "API_KEY": "abc"})
+
+	if err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	defer os.Remove(envFilePath)
+
+	info, err := os.Stat(envFilePath)
+
+	if err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	if info.Mode().Perm() != 0600 {
+		t.Fatalf("Env file should be readable only by the owner. mode: %s", info.Mode())
+	}
+
+	content, err := ioutil.ReadFile(envFilePath)
+
+	if err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	expected := "API_KEY=abc\nSECRET_KEY_BASE=s3cr3t\n"
+
+	if string(content) != expected {
+		t.Fatalf("Env file does not match. expected: %q, actual: %q", expected, string(content))
+	}
+}
+
+func TestHealthCheckSettings(t *testing.T) {
+	setup()
+
+	if len(v2Compose.HealthCheckSettings) != 0 {
+		t.Fatalf("HealthCheckSettings should be empty without x-paus-healthcheck. actual: %v", v2Compose.HealthCheckSettings)
+	}
+
+	expected := map[string]string{"path": "/health", "interval": "5"}
+
+	if !reflect.DeepEqual(v2ComposeLabels.HealthCheckSettings, expected) {
+		t.Fatalf("HealthCheckSettings do not match. expected: %v, actual: %v", expected, v2ComposeLabels.HealthCheckSettings)
+	}
+
+	newFilePath := filepath.Join("/tmp", "new-docker-compose-healthcheck.yml")
+	defer os.Remove(newFilePath)
+
+	if err := v2ComposeLabels.SaveAs(newFilePath); err != nil {
+		t.Fatalf("SaveAs() fails: %s", err.Error())
+	}
+
+	compose, err := NewCompose(dockerHost, newFilePath, projectName)
+
+	if err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	if !reflect.DeepEqual(compose.HealthCheckSettings, expected) {
+		t.Fatalf("HealthCheckSettings should be saved. expected: %v, actual: %v", expected, compose.HealthCheckSettings)
+	}
+
+	content := "version: '2'\nservices:\n  web:\n    image: nginx\nx-paus-healthcheck:\n  url: /\n"
+
+	if err := ioutil.WriteFile(newFilePath, []byte(content), 0644); err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	if _, err := NewCompose(dockerHost, newFilePath, projectName); err == nil {
+		t.Fatalf("Error should be raised with unknown healthcheck setting.")
+	}
+}
+
+func TestMultipleWebServices(t *testing.T) {
+	f, err := ioutil.TempFile("", "docker-compose")
+
+	if err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	defer os.Remove(f.Name())
+
+	content := "version: '2'\nservices:\n  web:\n    image: nginx\n    labels:\n      - paus.web=true\n  app:\n    image: nginx\n    labels:\n      - paus.web=true\n"
+
+	if _, err := f.WriteString(content); err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	f.Close()
+
+	if _, err := NewCompose(dockerHost, f.Name(), projectName); err == nil {
+		t.Fatalf("Error should be raised when multiple services are labeled as paus.web.")
+	}
+}
+
+func TestComposeError(t *testing.T) {
+	setup()
+
+	cause := errors.New("connection refused")
+	err := v2Compose.newError("start containers", []string{"web"}, cause)
+
+	expected := "Failed to start containers. projectName: paustest, services: [web]: connection refused"
+
+	if err.Error() != expected {
+		t.Fatalf("Error message does not match. expected: %s, actual: %s", expected, err.Error())
+	}
+
+	if errors.Cause(err) != cause {
+		t.Fatalf("Cause of ComposeError should be the original error. actual: %v", errors.Cause(err))
+	}
+}
+
+func TestWebService(t *testing.T) {
+	setup()
+
+	if v2Compose.WebService != "web" {
+		t.Fatalf("WebService should be web by default. actual: %s", v2Compose.WebService)
+	}
+
+	if v2Compose.WebPort() != "" {
+		t.Fatalf("WebPort should be empty without label. actual: %s", v2Compose.WebPort())
+	}
+
+	if v2ComposeLabels.WebService != "app" {
+		t.Fatalf("WebService should be taken from paus.web label. expected: app, actual: %s", v2ComposeLabels.WebService)
+	}
+
+	if !v2ComposeLabels.HasService("admin") {
+		t.Fatalf("admin service should be defined.")
+	}
+
+	if v2ComposeLabels.HasService("worker") {
+		t.Fatalf("worker service should not be defined.")
+	}
+
+	if v2ComposeLabels.WebPort() != "3000" {
+		t.Fatalf("WebPort should be taken from paus.port label. expected: 3000, actual: %s", v2ComposeLabels.WebPort())
+	}
+
+	v2ComposeLabels.InjectEnvironmentVariables(map[string]string{"FOO": "hoge"})
+	svc, _ := v2ComposeLabels.project.ServiceConfigs.Get("app")
+
+	if !contains(svc.Environment, "FOO=hoge") {
+		t.Fatalf("Environment variables should be injected into labeled web service. environments: %v", svc.Environment)
+	}
+}
+
+func TestComposeHookCommand(t *testing.T) {
+	setup()
+
+	expected := "bin/rake db:migrate"
+
+	if actual := v2ComposeLabels.HookCommand("pre-deploy"); actual != expected {
+		t.Fatalf("Hook command does not match. expected: %s, actual: %s", expected, actual)
+	}
+
+	if actual := v2ComposeLabels.HookCommand("post-deploy"); actual != "" {
+		t.Fatalf("Hook command should be empty without label. actual: %s", actual)
+	}
+
+	if actual := v2Compose.HookCommand("pre-deploy"); actual != "" {
+		t.Fatalf("Hook command should be empty without label. actual: %s", actual)
+	}
+}
+
+func TestRoutedServices(t *testing.T) {
+	setup()
+
+	routes, err := v2Compose.RoutedServices()
+
+	if err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	if len(routes) != 0 {
+		t.Fatalf("No service should be routed without label. actual: %v", routes)
+	}
+
+	routes, err = v2ComposeLabels.RoutedServices()
+
+	if err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	expected := map[string]string{"admin": "admin"}
+
+	if !reflect.DeepEqual(routes, expected) {
+		t.Fatalf("Routed services do not match. expected: %v, actual: %v", expected, routes)
+	}
+
+	if v2ComposeLabels.ServicePort("admin") != "" {
+		t.Fatalf("ServicePort should be empty without label. actual: %s", v2ComposeLabels.ServicePort("admin"))
+	}
+
+	svc, _ := v2ComposeLabels.project.ServiceConfigs.Get("db")
+	svc.Labels = map[string]string{"paus.route": "Admin"}
+
+	if _, err := v2ComposeLabels.RoutedServices(); err == nil {
+		t.Fatalf("Error should be raised with invalid route name.")
+	}
+
+	svc.Labels = map[string]string{"paus.route": "admin"}
+
+	if _, err := v2ComposeLabels.RoutedServices(); err == nil {
+		t.Fatalf("Error should be raised when route name is duplicated.")
+	}
+}
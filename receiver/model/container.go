@@ -1,14 +1,26 @@
 package model
 
 import (
-	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/fsouza/go-dockerclient"
 	"github.com/pkg/errors"
 )
 
+const (
+	dockerHealthCheckNone  = "NONE"
+	dockerHealthy          = "healthy"
+	dockerUnhealthy        = "unhealthy"
+	maxHealthCheckBodySize = 1024 * 1024
+)
+
 type Container struct {
 	ContainerId   string
 	client        *docker.Client
@@ -16,21 +28,59 @@ type Container struct {
 	exposedPort   docker.PortBinding
 }
 
-type HealthCheckFunc func(path string, try int)
+// HealthCheckFunc is called before each healthcheck. target is URL path in HTTP mode, or address in TCP mode.
+type HealthCheckFunc func(target string, try int)
+
+type portsByNumber []docker.Port
+
+func (p portsByNumber) Len() int      { return len(p) }
+func (p portsByNumber) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+func (p portsByNumber) Less(i, j int) bool {
+	pi, _ := strconv.Atoi(p[i].Port())
+	pj, _ := strconv.Atoi(p[j].Port())
+
+	if pi != pj {
+		return pi < pj
+	}
+
+	return p[i].Proto() < p[j].Proto()
+}
+
+// selectExposedPort picks the host binding of the given container port.
+// If port is empty, the lowest exposed port is picked so that the result is always the same.
+func selectExposedPort(ports map[docker.Port][]docker.PortBinding, port string) (docker.PortBinding, error) {
+	if port != "" {
+		if !strings.Contains(port, "/") {
+			port = port + "/tcp"
+		}
+
+		bindings, ok := ports[docker.Port(port)]
+
+		if !ok || len(bindings) == 0 {
+			return docker.PortBinding{}, errors.Errorf("Port %s is not exposed to host.", port)
+		}
+
+		return bindings[0], nil
+	}
 
-func firstExposedPort(ports map[docker.Port][]docker.PortBinding) docker.PortBinding {
-	ary := []docker.PortBinding{}
+	candidates := []docker.Port{}
 
-	for _, p := range ports {
-		for _, port := range p {
-			ary = append(ary, port)
+	for p, bindings := range ports {
+		if len(bindings) > 0 {
+			candidates = append(candidates, p)
 		}
 	}
 
-	return ary[0]
+	if len(candidates) == 0 {
+		return docker.PortBinding{}, errors.New("No port is exposed to host.")
+	}
+
+	sort.Sort(portsByNumber(candidates))
+
+	return ports[candidates[0]][0], nil
 }
 
-func ContainerFromID(dockerHost, containerId string) (*Container, error) {
+func ContainerFromID(dockerHost, containerId, port string) (*Container, error) {
 	client, _ := docker.NewClient(dockerHost)
 	containerInfo, err := client.InspectContainer(containerId)
 
@@ -38,28 +88,247 @@ func ContainerFromID(dockerHost, containerId string) (*Container, error) {
 		return nil, errors.Wrapf(err, "Failed to get container info. containerID %s", containerId)
 	}
 
-	exposedPort := firstExposedPort(containerInfo.NetworkSettings.Ports)
+	return ContainerFromInfo(client, containerInfo, port)
+}
+
+// ContainerFromInfo returns container routed through the given port of inspected container info
+func ContainerFromInfo(client *docker.Client, containerInfo *docker.Container, port string) (*Container, error) {
+	var ports map[docker.Port][]docker.PortBinding
+
+	if containerInfo.NetworkSettings != nil {
+		ports = containerInfo.NetworkSettings.Ports
+	}
+
+	exposedPort, err := selectExposedPort(ports, port)
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to select port to route. containerID %s", containerInfo.ID)
+	}
+
+	return &Container{containerInfo.ID, client, containerInfo, exposedPort}, nil
+}
+
+// hasDockerHealthCheck returns whether HEALTHCHECK is defined in the image, and not disabled by HEALTHCHECK NONE
+func hasDockerHealthCheck(config *docker.Config) bool {
+	if config == nil || config.Healthcheck == nil || len(config.Healthcheck.Test) == 0 {
+		return false
+	}
+
+	return config.Healthcheck.Test[0] != dockerHealthCheckNone
+}
+
+// dockerHealthStatus interprets State.Health.Status. done is false while the container is still starting.
+func dockerHealthStatus(status string) (healthy, done bool) {
+	switch status {
+	case dockerHealthy:
+		return true, true
+	case dockerUnhealthy:
+		return false, true
+	}
+
+	return false, false
+}
 
-	return &Container{containerId, client, containerInfo, exposedPort}, nil
+// HasDockerHealthCheck returns whether the container is checked by Docker's native HEALTHCHECK
+func (c *Container) HasDockerHealthCheck() bool {
+	return c.containerInfo != nil && hasDockerHealthCheck(c.containerInfo.Config)
 }
 
-func (c *Container) ExecuteHealthCheck(path string, interval, maxTry int, callback HealthCheckFunc) bool {
-	url := fmt.Sprintf("http://%s:%s%s", c.HostIP(), c.HostPort(), path)
+// ExecuteHealthCheck checks the container until it becomes healthy or healthCheck.MaxTry times.
+// If the image defines HEALTHCHECK, it waits for the health status reported by Docker instead of pinging the container.
+func (c *Container) ExecuteHealthCheck(healthCheck *HealthCheck, callback HealthCheckFunc) bool {
+	if c.HasDockerHealthCheck() {
+		return c.waitDockerHealthCheck(healthCheck, callback)
+	}
 
-	for i := 1; i <= maxTry; i++ {
-		callback(path, i)
+	address := net.JoinHostPort(c.HostIP(), c.HostPort())
+	timeout := time.Duration(healthCheck.Timeout) * time.Second
 
-		resp, err := http.Get(url)
-		if err == nil && resp.StatusCode == http.StatusOK {
+	for i := 1; i <= healthCheck.MaxTry; i++ {
+		var healthy bool
+
+		if healthCheck.Mode == HealthCheckModeTCP {
+			callback(address, i)
+			healthy = checkTCP(address, timeout)
+		} else {
+			callback(healthCheck.Path, i)
+			healthy = checkHTTP(address, healthCheck, timeout)
+		}
+
+		if healthy {
 			return true
 		}
 
-		time.Sleep(time.Duration(interval) * time.Second)
+		time.Sleep(time.Duration(healthCheck.Interval) * time.Second)
 	}
 
 	return false
 }
 
+func (c *Container) waitDockerHealthCheck(healthCheck *HealthCheck, callback HealthCheckFunc) bool {
+	for i := 1; i <= healthCheck.MaxTry; i++ {
+		callback("HEALTHCHECK of "+c.ContainerId, i)
+
+		containerInfo, err := c.client.InspectContainer(c.ContainerId)
+
+		if err == nil {
+			if healthy, done := dockerHealthStatus(containerInfo.State.Health.Status); done {
+				return healthy
+			}
+		}
+
+		time.Sleep(time.Duration(healthCheck.Interval) * time.Second)
+	}
+
+	return false
+}
+
+// oneOffOptions returns options to create one-off container which shares image, environment and network with the given container.
+// Labels are not copied, so that the one-off container is not regarded as a container of the compose service.
+func oneOffOptions(containerInfo *docker.Container, command []string) docker.CreateContainerOptions {
+	config := &docker.Config{
+		Image: containerInfo.Image,
+		Cmd:   command,
+	}
+
+	if containerInfo.Config != nil {
+		config.Env = containerInfo.Config.Env
+		config.User = containerInfo.Config.User
+		config.WorkingDir = containerInfo.Config.WorkingDir
+	}
+
+	hostConfig := &docker.HostConfig{}
+
+	if containerInfo.HostConfig != nil {
+		hostConfig.DNS = containerInfo.HostConfig.DNS
+		hostConfig.ExtraHosts = containerInfo.HostConfig.ExtraHosts
+		hostConfig.Links = containerInfo.HostConfig.Links
+		hostConfig.NetworkMode = containerInfo.HostConfig.NetworkMode
+	}
+
+	return docker.CreateContainerOptions{
+		Config:     config,
+		HostConfig: hostConfig,
+	}
+}
+
+// RunOneOff runs command in a new container created from the image of the given container, and returns its exit code.
+// Output of the command is streamed to output. The one-off container is removed after it exits.
+func RunOneOff(dockerHost, containerId string, command []string, output io.Writer) (int, error) {
+	client, _ := docker.NewClient(dockerHost)
+	containerInfo, err := client.InspectContainer(containerId)
+
+	if err != nil {
+		return 0, errors.Wrapf(err, "Failed to get container info. containerID %s", containerId)
+	}
+
+	options := oneOffOptions(containerInfo, command)
+	container, err := client.CreateContainer(options)
+
+	if err != nil {
+		return 0, errors.Wrapf(err, "Failed to create one-off container. image: %s", containerInfo.Image)
+	}
+
+	defer client.RemoveContainer(docker.RemoveContainerOptions{
+		ID:            container.ID,
+		RemoveVolumes: true,
+		Force:         true,
+	})
+
+	if err := client.StartContainer(container.ID, options.HostConfig); err != nil {
+		return 0, errors.Wrapf(err, "Failed to start one-off container. containerID: %s", container.ID)
+	}
+
+	err = client.Logs(docker.LogsOptions{
+		Container:    container.ID,
+		OutputStream: output,
+		ErrorStream:  output,
+		Follow:       true,
+		Stdout:       true,
+		Stderr:       true,
+	})
+
+	if err != nil {
+		return 0, errors.Wrapf(err, "Failed to read output of one-off container. containerID: %s", container.ID)
+	}
+
+	exitCode, err := client.WaitContainer(container.ID)
+
+	if err != nil {
+		return 0, errors.Wrapf(err, "Failed to wait one-off container. containerID: %s", container.ID)
+	}
+
+	return exitCode, nil
+}
+
+// checkHTTP sends a single request without following redirects, so that 3xx status can be accepted.
+// Whole request including reading response body must be finished in timeout.
+func checkHTTP(address string, healthCheck *HealthCheck, timeout time.Duration) bool {
+	transport := &http.Transport{
+		Dial: func(network, addr string) (net.Conn, error) {
+			conn, err := net.DialTimeout(network, addr, timeout)
+
+			if err != nil {
+				return nil, err
+			}
+
+			if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+				conn.Close()
+				return nil, err
+			}
+
+			return conn, nil
+		},
+		DisableKeepAlives: true,
+	}
+
+	req, err := http.NewRequest("GET", "http://"+address+healthCheck.Path, nil)
+
+	if err != nil {
+		return false
+	}
+
+	if healthCheck.Host != "" {
+		req.Host = healthCheck.Host
+	}
+
+	resp, err := transport.RoundTrip(req)
+
+	if err != nil {
+		return false
+	}
+
+	defer resp.Body.Close()
+
+	if !healthCheck.AcceptsStatus(resp.StatusCode) {
+		return false
+	}
+
+	if healthCheck.Body == "" {
+		return true
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxHealthCheckBodySize))
+
+	if err != nil {
+		return false
+	}
+
+	return strings.Contains(string(body), healthCheck.Body)
+}
+
+func checkTCP(address string, timeout time.Duration) bool {
+	conn, err := net.DialTimeout("tcp", address, timeout)
+
+	if err != nil {
+		return false
+	}
+
+	conn.Close()
+
+	return true
+}
+
 func (c *Container) HostIP() string {
 	return c.exposedPort.HostIP
 }
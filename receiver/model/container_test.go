@@ -0,0 +1,222 @@
+package model
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+func containerFromListener(listener net.Listener) *Container {
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+
+	return &Container{
+		ContainerId: "abcdef",
+		exposedPort: docker.PortBinding{
+			HostIP:   host,
+			HostPort: port,
+		},
+	}
+}
+
+func TestSelectExposedPort(t *testing.T) {
+	ports := map[docker.Port][]docker.PortBinding{
+		"9100/tcp": []docker.PortBinding{{HostIP: "0.0.0.0", HostPort: "32769"}},
+		"8080/tcp": []docker.PortBinding{{HostIP: "0.0.0.0", HostPort: "32768"}},
+		"443/tcp":  []docker.PortBinding{},
+	}
+
+	for i := 0; i < 10; i++ {
+		binding, err := selectExposedPort(ports, "")
+
+		if err != nil {
+			t.Fatalf("Unexpected error has been raised. error: %s", err)
+		}
+
+		if binding.HostPort != "32768" {
+			t.Fatalf("The lowest exposed port should be selected. expected: 32768, actual: %s", binding.HostPort)
+		}
+	}
+
+	binding, err := selectExposedPort(ports, "9100")
+
+	if err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	if binding.HostPort != "32769" {
+		t.Fatalf("Specified port should be selected. expected: 32769, actual: %s", binding.HostPort)
+	}
+
+	if _, err := selectExposedPort(ports, "443/tcp"); err == nil {
+		t.Fatalf("Error should be raised when specified port is not bound to host.")
+	}
+
+	if _, err := selectExposedPort(map[docker.Port][]docker.PortBinding{}, ""); err == nil {
+		t.Fatalf("Error should be raised when no port is exposed.")
+	}
+}
+
+func TestExecuteHealthCheckHTTP(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ping":
+			fmt.Fprint(w, "status: OK, host: "+r.Host)
+		case "/redirect":
+			http.Redirect(w, r, "/ping", http.StatusFound)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	container := containerFromListener(server.Listener)
+	callback := func(target string, try int) {}
+
+	testcases := []struct {
+		healthCheck *HealthCheck
+		expected    bool
+	}{
+		{&HealthCheck{Path: "/ping", Statuses: []StatusRange{{200, 200}}}, true},
+		{&HealthCheck{Path: "/notfound", Statuses: []StatusRange{{200, 200}}}, false},
+		{&HealthCheck{Path: "/notfound", Statuses: []StatusRange{{200, 299}, {404, 404}}}, true},
+		{&HealthCheck{Path: "/redirect", Statuses: []StatusRange{{200, 200}}}, false},
+		{&HealthCheck{Path: "/redirect", Statuses: []StatusRange{{200, 399}}}, true},
+		{&HealthCheck{Path: "/ping", Statuses: []StatusRange{{200, 200}}, Body: "OK"}, true},
+		{&HealthCheck{Path: "/ping", Statuses: []StatusRange{{200, 200}}, Body: "NG"}, false},
+		{&HealthCheck{Path: "/ping", Statuses: []StatusRange{{200, 200}}, Host: "app.example.com", Body: "host: app.example.com"}, true},
+	}
+
+	for _, tc := range testcases {
+		tc.healthCheck.Mode = HealthCheckModeHTTP
+		tc.healthCheck.MaxTry = 1
+		tc.healthCheck.Timeout = 1
+
+		if actual := container.ExecuteHealthCheck(tc.healthCheck, callback); actual != tc.expected {
+			t.Fatalf("Healthcheck result does not match. healthcheck: %+v, expected: %t, actual: %t", tc.healthCheck, tc.expected, actual)
+		}
+	}
+}
+
+func TestExecuteHealthCheckTCP(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	container := containerFromListener(listener)
+	healthCheck := &HealthCheck{
+		MaxTry:  1,
+		Mode:    HealthCheckModeTCP,
+		Timeout: 1,
+	}
+
+	var target string
+	callback := func(t string, try int) {
+		target = t
+	}
+
+	if !container.ExecuteHealthCheck(healthCheck, callback) {
+		t.Fatalf("Healthcheck should succeed when port is listened.")
+	}
+
+	if target != listener.Addr().String() {
+		t.Fatalf("Callback should receive address. expected: %s, actual: %s", listener.Addr().String(), target)
+	}
+
+	listener.Close()
+
+	if container.ExecuteHealthCheck(healthCheck, callback) {
+		t.Fatalf("Healthcheck should fail when port is not listened.")
+	}
+}
+
+func TestHasDockerHealthCheck(t *testing.T) {
+	testcases := []struct {
+		config   *docker.Config
+		expected bool
+	}{
+		{nil, false},
+		{&docker.Config{}, false},
+		{&docker.Config{Healthcheck: &docker.HealthConfig{}}, false},
+		{&docker.Config{Healthcheck: &docker.HealthConfig{Test: []string{"NONE"}}}, false},
+		{&docker.Config{Healthcheck: &docker.HealthConfig{Test: []string{"CMD-SHELL", "curl -f http://localhost/ || exit 1"}}}, true},
+	}
+
+	for _, tc := range testcases {
+		if actual := hasDockerHealthCheck(tc.config); actual != tc.expected {
+			t.Fatalf("Result does not match. config: %+v, expected: %t, actual: %t", tc.config, tc.expected, actual)
+		}
+	}
+
+	if (&Container{ContainerId: "abcdef"}).HasDockerHealthCheck() {
+		t.Fatalf("Container without inspected info should not be regarded as having HEALTHCHECK.")
+	}
+}
+
+func TestDockerHealthStatus(t *testing.T) {
+	testcases := []struct {
+		status  string
+		healthy bool
+		done    bool
+	}{
+		{"starting", false, false},
+		{"healthy", true, true},
+		{"unhealthy", false, true},
+		{"", false, false},
+	}
+
+	for _, tc := range testcases {
+		healthy, done := dockerHealthStatus(tc.status)
+
+		if healthy != tc.healthy || done != tc.done {
+			t.Fatalf("Result does not match. status: %s, expected: (%t, %t), actual: (%t, %t)", tc.status, tc.healthy, tc.done, healthy, done)
+		}
+	}
+}
+
+func TestOneOffOptions(t *testing.T) {
+	containerInfo := &docker.Container{
+		Image: "sha256:0123456789ab",
+		Config: &docker.Config{
+			Env:        []string{"RAILS_ENV=production"},
+			Labels:     map[string]string{"com.docker.compose.service": "web"},
+			User:       "app",
+			WorkingDir: "/app",
+		},
+		HostConfig: &docker.HostConfig{
+			Links:       []string{"/app_db_1:/app_web_1/db"},
+			NetworkMode: "app_default",
+		},
+	}
+	command := []string{"/bin/sh", "-c", "bin/rake db:migrate"}
+
+	options := oneOffOptions(containerInfo, command)
+
+	expected := &docker.Config{
+		Image:      "sha256:0123456789ab",
+		Cmd:        command,
+		Env:        []string{"RAILS_ENV=production"},
+		User:       "app",
+		WorkingDir: "/app",
+	}
+
+	if !reflect.DeepEqual(options.Config, expected) {
+		t.Fatalf("Config does not match. expected: %+v, actual: %+v", expected, options.Config)
+	}
+
+	if !reflect.DeepEqual(options.HostConfig, containerInfo.HostConfig) {
+		t.Fatalf("HostConfig does not match. expected: %+v, actual: %+v", containerInfo.HostConfig, options.HostConfig)
+	}
+
+	options = oneOffOptions(&docker.Container{Image: "sha256:0123456789ab"}, command)
+
+	if options.Config.Image != "sha256:0123456789ab" || options.HostConfig == nil {
+		t.Fatalf("Options should be built without container config. options: %+v", options)
+	}
+}
@@ -1,23 +1,81 @@
 package model
 
 import (
+	"encoding/json"
 	"path/filepath"
 	"regexp"
+	"strings"
 
 	"github.com/pkg/errors"
 )
 
+const (
+	DeploymentStatusActive  = "active"
+	DeploymentStatusStopped = "stopped"
+)
+
 var (
-	refnameRegexp = regexp.MustCompile(`^refs/heads/`)
+	refnameRegexp      = regexp.MustCompile(`^refs/heads/`)
+	zeroRevisionRegexp = regexp.MustCompile(`^0+$`)
 )
 
 type Deployment struct {
 	App             *Application
 	Branch          string
+	BranchDeleted   bool
 	ComposeFilePath string
+	ContainerIDs    []string
+	Fingerprint     string
+	LogFilePath     string
 	ProjectName     string
+	Pusher          string
 	Revision        string
+	Scale           int
+	Status          string
 	Timestamp       string
+	URLs            []string
+	WebPort         string
+	WebService      string
+}
+
+// DeploymentRecord is deployment metadata stored as JSON at /paus/users/<user>/apps/<app>/deployments/<timestamp>
+type DeploymentRecord struct {
+	Revision        string   `json:"revision"`
+	Branch          string   `json:"branch,omitempty"`
+	Pusher          string   `json:"pusher,omitempty"`
+	Fingerprint     string   `json:"fingerprint,omitempty"`
+	ProjectName     string   `json:"project_name,omitempty"`
+	ComposeFilePath string   `json:"compose_file_path,omitempty"`
+	ContainerIDs    []string `json:"container_ids,omitempty"`
+	URLs            []string `json:"urls,omitempty"`
+	Status          string   `json:"status,omitempty"`
+	WebService      string   `json:"web_service,omitempty"`
+	WebPort         string   `json:"web_port,omitempty"`
+	Scale           int      `json:"scale,omitempty"`
+}
+
+// ParseDeploymentRecord parses stored deployment metadata.
+// Old format, which is only the revision, is also accepted.
+func ParseDeploymentRecord(value string) (*DeploymentRecord, error) {
+	value = strings.TrimSpace(value)
+
+	if !strings.HasPrefix(value, "{") {
+		return &DeploymentRecord{
+			Revision: value,
+		}, nil
+	}
+
+	var record DeploymentRecord
+
+	if err := json.Unmarshal([]byte(value), &record); err != nil {
+		return nil, errors.Wrap(err, "Failed to parse deployment record.")
+	}
+
+	if record.Revision == "" {
+		return nil, errors.Errorf("Deployment record does not have revision. record: %s", value)
+	}
+
+	return &record, nil
 }
 
 // args:
@@ -30,23 +88,91 @@ func DeploymentFromArgs(app *Application, args []string, timestamp, repositoryDi
 	revision := args[1]
 	branch := refnameRegexp.ReplaceAllString(args[4], "")
 
-	return NewDeployment(app, branch, revision, timestamp, repositoryDir), nil
+	deployment := NewDeployment(app, branch, revision, timestamp, repositoryDir)
+	deployment.Pusher = args[2]
+	deployment.Fingerprint = args[3]
+
+	// newrev of branch deletion push is all zeros
+	deployment.BranchDeleted = zeroRevisionRegexp.MatchString(revision)
+
+	return deployment, nil
 }
 
 func NewDeployment(app *Application, branch, revision, timestamp, repositoryDir string) *Deployment {
 	projectName := app.Repository + "-" + revision[0:8]
 	composeFilePath := filepath.Join(repositoryDir, app.Username, projectName, "docker-compose-"+timestamp+".yml")
+	logFilePath := filepath.Join(repositoryDir, app.Username, projectName, "deploy-"+timestamp+".log")
 
 	return &Deployment{
 		App:             app,
 		Branch:          branch,
 		ComposeFilePath: composeFilePath,
+		LogFilePath:     logFilePath,
 		ProjectName:     projectName,
 		Revision:        revision,
 		Timestamp:       timestamp,
 	}
 }
 
+// DeploymentFromRecord restores deployment from its stored record
+func DeploymentFromRecord(app *Application, timestamp string, record *DeploymentRecord, repositoryDir string) *Deployment {
+	deployment := NewDeployment(app, record.Branch, record.Revision, timestamp, repositoryDir)
+
+	if record.ComposeFilePath != "" {
+		deployment.ComposeFilePath = record.ComposeFilePath
+	}
+
+	if record.ProjectName != "" {
+		deployment.ProjectName = record.ProjectName
+	}
+
+	deployment.ContainerIDs = record.ContainerIDs
+	deployment.Fingerprint = record.Fingerprint
+	deployment.Pusher = record.Pusher
+	deployment.Scale = record.Scale
+	deployment.Status = record.Status
+	deployment.URLs = record.URLs
+	deployment.WebPort = record.WebPort
+	deployment.WebService = record.WebService
+
+	return deployment
+}
+
+// LoadDeployment restores deployment registered at the timestamp
+func LoadDeployment(app *Application, timestamp, repositoryDir string) (*Deployment, error) {
+	record, err := app.DeploymentRecord(timestamp)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return DeploymentFromRecord(app, timestamp, record, repositoryDir), nil
+}
+
+// Record returns metadata of the deployment to store
+func (d *Deployment) Record() *DeploymentRecord {
+	status := d.Status
+
+	if status == "" {
+		status = DeploymentStatusActive
+	}
+
+	return &DeploymentRecord{
+		Revision:        d.Revision,
+		Branch:          d.Branch,
+		Pusher:          d.Pusher,
+		Fingerprint:     d.Fingerprint,
+		ProjectName:     d.ProjectName,
+		ComposeFilePath: d.ComposeFilePath,
+		ContainerIDs:    d.ContainerIDs,
+		URLs:            d.URLs,
+		Status:          status,
+		WebService:      d.WebService,
+		WebPort:         d.WebPort,
+		Scale:           d.Scale,
+	}
+}
+
 func (d *Deployment) Register() error {
-	return d.App.RegisterMetadata(d.Revision, d.Timestamp)
+	return d.App.RegisterMetadata(d.Timestamp, d.Record())
 }
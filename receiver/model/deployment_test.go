@@ -1,7 +1,10 @@
 package model
 
 import (
+	"reflect"
 	"testing"
+
+	"github.com/dtan4/paus-gitreceive/receiver/store"
 )
 
 func TestDeploymentFromArgs(t *testing.T) {
@@ -49,6 +52,26 @@ func TestDeploymentFromArgs(t *testing.T) {
 	if actual != expected {
 		t.Fatalf("Branch does not match. expected: %s actual: %s", expected, actual)
 	}
+
+	if deployment.BranchDeleted {
+		t.Fatalf("BranchDeleted should be false for ordinary push.")
+	}
+
+	if deployment.Pusher != "dtan4" || deployment.Fingerprint != "4c:1f:92:b9:43:2b:23:0b:c0:e8:ab:12:cd:34:ef:56" {
+		t.Fatalf("Pusher does not match. pusher: %s, fingerprint: %s", deployment.Pusher, deployment.Fingerprint)
+	}
+
+	args[1] = "0000000000000000000000000000000000000000"
+
+	deployment, err = DeploymentFromArgs(app, args, timestamp, repositoryDir)
+
+	if err != nil {
+		t.Fatalf("Error should not be raised.")
+	}
+
+	if !deployment.BranchDeleted {
+		t.Fatalf("BranchDeleted should be true when newrev is all zeros.")
+	}
 }
 
 func TestNewDeployment(t *testing.T) {
@@ -77,6 +100,13 @@ func TestNewDeployment(t *testing.T) {
 		t.Fatalf("ComposeFilePath does not match. expected: %s actual: %s", expected, actual)
 	}
 
+	expected = "/repos/user/user-repository-19fb23cd/deploy-1467181319.log"
+	actual = deployment.LogFilePath
+
+	if actual != expected {
+		t.Fatalf("LogFilePath does not match. expected: %s actual: %s", expected, actual)
+	}
+
 	expected = "user-repository-19fb23cd"
 	actual = deployment.ProjectName
 
@@ -84,3 +114,83 @@ func TestNewDeployment(t *testing.T) {
 		t.Fatalf("ProjectName does not match. expected: %s actual: %s", expected, actual)
 	}
 }
+
+func TestParseDeploymentRecord(t *testing.T) {
+	testcases := []struct {
+		value    string
+		expected *DeploymentRecord
+	}{
+		{
+			"19fb23cd71a4cf2eab00ad1a393e40de4ed61531",
+			&DeploymentRecord{Revision: "19fb23cd71a4cf2eab00ad1a393e40de4ed61531"},
+		},
+		{
+			`{"revision":"19fb23cd71a4cf2eab00ad1a393e40de4ed61531","branch":"master","container_ids":["abcdef"],"urls":["http://dtan4-app.pausapp.com"],"status":"stopped","web_service":"web","web_port":"8080","scale":2}`,
+			&DeploymentRecord{
+				Revision:     "19fb23cd71a4cf2eab00ad1a393e40de4ed61531",
+				Branch:       "master",
+				ContainerIDs: []string{"abcdef"},
+				URLs:         []string{"http://dtan4-app.pausapp.com"},
+				Status:       DeploymentStatusStopped,
+				WebService:   "web",
+				WebPort:      "8080",
+				Scale:        2,
+			},
+		},
+	}
+
+	for _, tc := range testcases {
+		actual, err := ParseDeploymentRecord(tc.value)
+
+		if err != nil {
+			t.Fatalf("Unexpected error has been raised. error: %s", err)
+		}
+
+		if !reflect.DeepEqual(actual, tc.expected) {
+			t.Fatalf("Deployment record does not match. expected: %+v, actual: %+v", tc.expected, actual)
+		}
+	}
+
+	for _, value := range []string{`{"revision":`, `{"branch":"master"}`} {
+		if _, err := ParseDeploymentRecord(value); err == nil {
+			t.Fatalf("Error should be raised with invalid record. value: %s", value)
+		}
+	}
+}
+
+func TestLoadDeployment(t *testing.T) {
+	memory := store.NewMemory()
+	app := &Application{
+		Repository: "dtan4-app",
+		Username:   "dtan4",
+		AppName:    "app",
+		store:      memory,
+	}
+
+	deployment := NewDeployment(app, "feature/login", "19fb23cd71a4cf2eab00ad1a393e40de4ed61531", "1467181319", "/repos")
+	deployment.ComposeFilePath = "/data/docker-compose-1467181319.yml"
+	deployment.ContainerIDs = []string{"abcdef", "123456"}
+	deployment.Fingerprint = "4c:1f:92:b9:43:2b:23:0b:c0:e8:ab:12:cd:34:ef:56"
+	deployment.Pusher = "dtan4"
+	deployment.Scale = 2
+	deployment.URLs = []string{"http://dtan4-app.pausapp.com"}
+	deployment.WebPort = "8080"
+	deployment.WebService = "web"
+
+	if err := deployment.Register(); err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	actual, err := LoadDeployment(app, "1467181319", "/repos")
+
+	if err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	expected := *deployment
+	expected.Status = DeploymentStatusActive
+
+	if !reflect.DeepEqual(actual, &expected) {
+		t.Fatalf("Deployment does not match. expected: %+v, actual: %+v", &expected, actual)
+	}
+}
@@ -0,0 +1,142 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/dtan4/paus-gitreceive/receiver/util"
+	"github.com/pkg/errors"
+)
+
+const (
+	EventStatusFailed    = "failed"
+	EventStatusSucceeded = "succeeded"
+)
+
+// Event is a record of one deploy phase
+type Event struct {
+	Phase     string    `json:"phase"`
+	Status    string    `json:"status"`
+	StartedAt time.Time `json:"started_at"`
+	Duration  float64   `json:"duration"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// EventLog records deploy phases of the deployment.
+// Every event is persisted as soon as it is recorded, so that the log remains even if deploy aborts midway.
+type EventLog struct {
+	deployment *Deployment
+	events     []*Event
+	mu         sync.Mutex
+	now        func() time.Time
+	redactor   *util.Redactor
+}
+
+func NewEventLog(deployment *Deployment) *EventLog {
+	return &EventLog{
+		deployment: deployment,
+		events:     []*Event{},
+		now:        time.Now,
+	}
+}
+
+// Record runs fn as the phase, and records its start time, duration and outcome.
+// Error returned by fn is returned as is. Failure of persisting the event is only reported to stderr,
+// because it must not abort deploy.
+func (l *EventLog) Record(phase string, fn func() error) error {
+	startedAt := l.now()
+	err := fn()
+
+	event := &Event{
+		Phase:     phase,
+		Status:    EventStatusSucceeded,
+		StartedAt: startedAt,
+		Duration:  l.now().Sub(startedAt).Seconds(),
+	}
+
+	if err != nil {
+		event.Status = EventStatusFailed
+		event.Error = l.redactor.Redact(err.Error())
+	}
+
+	if e := l.append(event); e != nil {
+		fmt.Fprintf(os.Stderr, "=====> Failed to record deploy event. error: %s\n", e)
+	}
+
+	return err
+}
+
+// SetRedactor sets redactor to mask secrets in error messages of events
+func (l *EventLog) SetRedactor(redactor *util.Redactor) {
+	l.redactor = redactor
+}
+
+// Events returns events recorded so far
+func (l *EventLog) Events() []*Event {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	events := make([]*Event, len(l.events))
+	copy(events, l.events)
+
+	return events
+}
+
+func (l *EventLog) append(event *Event) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.events = append(l.events, event)
+
+	if err := l.writeFile(event); err != nil {
+		return err
+	}
+
+	return l.writeStore()
+}
+
+// writeFile appends event to the log file as a JSON line
+func (l *EventLog) writeFile(event *Event) error {
+	logFilePath := l.deployment.LogFilePath
+
+	if err := os.MkdirAll(filepath.Dir(logFilePath), 0755); err != nil {
+		return errors.Wrapf(err, "Failed to create directory %s.", filepath.Dir(logFilePath))
+	}
+
+	fp, err := os.OpenFile(logFilePath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+
+	if err != nil {
+		return errors.Wrapf(err, "Failed to open %s.", logFilePath)
+	}
+
+	defer fp.Close()
+
+	b, err := json.Marshal(event)
+
+	if err != nil {
+		return errors.Wrap(err, "Failed to generate event JSON.")
+	}
+
+	if _, err := fp.Write(append(b, '\n')); err != nil {
+		return errors.Wrapf(err, "Failed to write event to %s.", logFilePath)
+	}
+
+	return nil
+}
+
+// writeStore saves all events recorded so far, as a JSON array
+func (l *EventLog) writeStore() error {
+	app := l.deployment.App
+
+	b, err := json.Marshal(l.events)
+
+	if err != nil {
+		return errors.Wrap(err, "Failed to generate events JSON.")
+	}
+
+	return app.store.Set(eventsKey(app, l.deployment.Timestamp), string(b))
+}
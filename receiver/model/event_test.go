@@ -0,0 +1,153 @@
+package model
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dtan4/paus-gitreceive/receiver/store"
+	"github.com/dtan4/paus-gitreceive/receiver/util"
+	"github.com/pkg/errors"
+)
+
+func TestEventLogRecord(t *testing.T) {
+	repositoryDir, err := ioutil.TempDir("", "paus-event")
+
+	if err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	defer os.RemoveAll(repositoryDir)
+
+	memory := store.NewMemory()
+	app := &Application{
+		Repository: "dtan4-app",
+		Username:   "dtan4",
+		AppName:    "app",
+		store:      memory,
+	}
+	deployment := NewDeployment(app, "master", "19fb23cd71a4cf2eab00ad1a393e40de4ed61531", "1467181319", repositoryDir)
+
+	eventLog := NewEventLog(deployment)
+	startedAt := time.Date(2016, 7, 1, 0, 0, 0, 0, time.UTC)
+	now := startedAt
+	eventLog.now = func() time.Time {
+		t := now
+		now = now.Add(1500 * time.Millisecond)
+		return t
+	}
+
+	if err := eventLog.Record("build", func() error { return nil }); err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	cause := errors.New("image not found")
+
+	if err := eventLog.Record("pull", func() error { return cause }); err != cause {
+		t.Fatalf("Error of the phase should be returned as is. actual: %v", err)
+	}
+
+	expected := []*Event{
+		{Phase: "build", Status: "succeeded", StartedAt: startedAt, Duration: 1.5},
+		{Phase: "pull", Status: "failed", StartedAt: startedAt.Add(3 * time.Second), Duration: 1.5, Error: "image not found"},
+	}
+
+	events, err := app.Events("1467181319")
+
+	if err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	if len(events) != len(expected) {
+		t.Fatalf("Number of events does not match. expected: %d, actual: %d", len(expected), len(events))
+	}
+
+	for i, event := range events {
+		if *event != *expected[i] || !event.StartedAt.Equal(expected[i].StartedAt) {
+			t.Fatalf("Event does not match. expected: %+v, actual: %+v", expected[i], event)
+		}
+	}
+
+	content, err := ioutil.ReadFile(deployment.LogFilePath)
+
+	if err != nil {
+		t.Fatalf("Log file is not written. error: %s", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+
+	if len(lines) != len(expected) {
+		t.Fatalf("Number of log lines does not match. expected: %d, actual: %d", len(expected), len(lines))
+	}
+
+	var event Event
+
+	if err := json.Unmarshal([]byte(lines[1]), &event); err != nil {
+		t.Fatalf("Log line is not JSON. line: %s", lines[1])
+	}
+
+	if event.Phase != "pull" || event.Status != "failed" || event.Error != "image not found" {
+		t.Fatalf("Logged event does not match. expected: %+v, actual: %+v", expected[1], event)
+	}
+
+	if err := app.DeleteEvents("1467181319"); err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	events, err = app.Events("1467181319")
+
+	if err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	if len(events) != 0 {
+		t.Fatalf("Events should be deleted. actual: %v", events)
+	}
+}
+
+func TestEventLogRedactor(t *testing.T) {
+	dir, err := ioutil.TempDir("", "paus-event")
+
+	if err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	app := &Application{
+		Repository: "dtan4-app",
+		Username:   "dtan4",
+		AppName:    "app",
+		store:      store.NewMemory(),
+	}
+	deployment := NewDeployment(app, "master", "19fb23cd71a4cf2eab00ad1a393e40de4ed61531", "1467181319", dir)
+
+	eventLog := NewEventLog(deployment)
+	eventLog.SetRedactor(util.NewRedactor(map[string]string{"SECRET": "s3cr3t"}))
+
+	err = eventLog.Record("up", func() error {
+		return errors.New("Failed with s3cr3t")
+	})
+
+	if err == nil {
+		t.Fatalf("Error should be returned as is.")
+	}
+
+	if actual := eventLog.Events()[0].Error; actual != "Failed with [REDACTED]" {
+		t.Fatalf("Secret should be masked in event. error: %s", actual)
+	}
+
+	content, err := ioutil.ReadFile(deployment.LogFilePath)
+
+	if err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	if bytes.Contains(content, []byte("s3cr3t")) {
+		t.Fatalf("Secret should not be written to deploy log. content: %s", content)
+	}
+}
@@ -0,0 +1,176 @@
+package model
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	HealthCheckModeHTTP = "http"
+	HealthCheckModeTCP  = "tcp"
+
+	defaultHealthCheckStatus  = "200"
+	defaultHealthCheckTimeout = 5
+)
+
+var (
+	// HealthCheckSettingNames are names of healthcheck settings, used as etcd keys and keys of x-paus-healthcheck section
+	HealthCheckSettingNames = []string{"body", "host", "interval", "max-try", "mode", "path", "status", "timeout"}
+)
+
+type HealthCheck struct {
+	Body     string
+	Host     string
+	Interval int
+	MaxTry   int
+	Mode     string
+	Path     string
+	Statuses []StatusRange
+	Timeout  int
+}
+
+// NewHealthCheck builds healthcheck from settings, a map of setting name and its value
+func NewHealthCheck(settings map[string]string) (*HealthCheck, error) {
+	mode := settings["mode"]
+
+	if mode == "" {
+		mode = HealthCheckModeHTTP
+	}
+
+	if mode != HealthCheckModeHTTP && mode != HealthCheckModeTCP {
+		return nil, errors.Errorf("Healthcheck mode must be %s or %s. mode: %s", HealthCheckModeHTTP, HealthCheckModeTCP, mode)
+	}
+
+	path := settings["path"]
+
+	if mode == HealthCheckModeHTTP && path == "" {
+		return nil, errors.Errorf("Healthcheck path must be set in %s mode.", mode)
+	}
+
+	interval, err := requiredIntSetting(settings, "interval", 0)
+
+	if err != nil {
+		return nil, err
+	}
+
+	maxTry, err := requiredIntSetting(settings, "max-try", 1)
+
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := defaultHealthCheckTimeout
+
+	if settings["timeout"] != "" {
+		if timeout, err = requiredIntSetting(settings, "timeout", 1); err != nil {
+			return nil, err
+		}
+	}
+
+	status := settings["status"]
+
+	if status == "" {
+		status = defaultHealthCheckStatus
+	}
+
+	statuses, err := ParseStatusRanges(status)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &HealthCheck{
+		Body:     settings["body"],
+		Host:     settings["host"],
+		Interval: interval,
+		MaxTry:   maxTry,
+		Mode:     mode,
+		Path:     path,
+		Statuses: statuses,
+		Timeout:  timeout,
+	}, nil
+}
+
+func requiredIntSetting(settings map[string]string, name string, min int) (int, error) {
+	value, ok := settings[name]
+
+	if !ok || value == "" {
+		return 0, errors.Errorf("Healthcheck %s must be set.", name)
+	}
+
+	n, err := strconv.Atoi(value)
+
+	if err != nil {
+		return 0, errors.Wrapf(err, "Healthcheck %s must be an integer. %s: %s", name, name, value)
+	}
+
+	if n < min {
+		return 0, errors.Errorf("Healthcheck %s must not be less than %d. %s: %d", name, min, name, n)
+	}
+
+	return n, nil
+}
+
+// StatusRange is a range of accepted HTTP status codes, both ends inclusive
+type StatusRange struct {
+	Min int
+	Max int
+}
+
+// ParseStatusRanges parses comma-separated status codes, e.g. "200", "2xx,3xx" or "200-299,304"
+func ParseStatusRanges(statuses string) ([]StatusRange, error) {
+	ranges := []StatusRange{}
+
+	for _, s := range strings.Split(statuses, ",") {
+		s = strings.ToLower(strings.TrimSpace(s))
+
+		if s == "" {
+			continue
+		}
+
+		var r StatusRange
+		var err error
+
+		switch {
+		case len(s) == 3 && strings.HasSuffix(s, "xx"):
+			var class int
+
+			class, err = strconv.Atoi(s[0:1])
+			r = StatusRange{class * 100, class*100 + 99}
+		case strings.Contains(s, "-"):
+			ss := strings.SplitN(s, "-", 2)
+
+			if r.Min, err = strconv.Atoi(ss[0]); err == nil {
+				r.Max, err = strconv.Atoi(ss[1])
+			}
+		default:
+			r.Min, err = strconv.Atoi(s)
+			r.Max = r.Min
+		}
+
+		if err != nil || r.Min < 100 || r.Max > 599 || r.Min > r.Max {
+			return nil, errors.Errorf("Invalid healthcheck status. status: %s", s)
+		}
+
+		ranges = append(ranges, r)
+	}
+
+	if len(ranges) == 0 {
+		return nil, errors.Errorf("No healthcheck status is specified. statuses: %s", statuses)
+	}
+
+	return ranges, nil
+}
+
+// AcceptsStatus returns whether the given HTTP status code is regarded as healthy
+func (h *HealthCheck) AcceptsStatus(code int) bool {
+	for _, r := range h.Statuses {
+		if r.Min <= code && code <= r.Max {
+			return true
+		}
+	}
+
+	return false
+}
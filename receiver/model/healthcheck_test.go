@@ -0,0 +1,97 @@
+package model
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNewHealthCheck(t *testing.T) {
+	healthCheck, err := NewHealthCheck(map[string]string{
+		"body":     "OK",
+		"host":     "app.example.com",
+		"interval": "3",
+		"max-try":  "10",
+		"path":     "/ping",
+		"status":   "2xx,304",
+		"timeout":  "1",
+	})
+
+	if err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	expected := &HealthCheck{
+		Body:     "OK",
+		Host:     "app.example.com",
+		Interval: 3,
+		MaxTry:   10,
+		Mode:     "http",
+		Path:     "/ping",
+		Statuses: []StatusRange{{200, 299}, {304, 304}},
+		Timeout:  1,
+	}
+
+	if !reflect.DeepEqual(healthCheck, expected) {
+		t.Fatalf("Healthcheck does not match. expected: %+v, actual: %+v", expected, healthCheck)
+	}
+
+	if _, err := NewHealthCheck(map[string]string{"mode": "tcp", "interval": "3", "max-try": "10"}); err != nil {
+		t.Fatalf("Path should not be required in TCP mode. error: %s", err)
+	}
+
+	for _, settings := range []map[string]string{
+		{"interval": "3", "max-try": "10"},
+		{"path": "/", "max-try": "10"},
+		{"path": "/", "interval": "3"},
+		{"path": "/", "interval": "three", "max-try": "10"},
+		{"path": "/", "interval": "3", "max-try": "0"},
+		{"path": "/", "interval": "3", "max-try": "10", "timeout": "0"},
+		{"path": "/", "interval": "3", "max-try": "10", "mode": "udp"},
+		{"path": "/", "interval": "3", "max-try": "10", "status": "ok"},
+	} {
+		if _, err := NewHealthCheck(settings); err == nil {
+			t.Fatalf("Error should be raised with invalid settings. settings: %v", settings)
+		}
+	}
+}
+
+func TestParseStatusRanges(t *testing.T) {
+	testcases := []struct {
+		statuses string
+		expected []StatusRange
+	}{
+		{"200", []StatusRange{{200, 200}}},
+		{"2xx,3XX", []StatusRange{{200, 299}, {300, 399}}},
+		{"200-299, 304", []StatusRange{{200, 299}, {304, 304}}},
+	}
+
+	for _, tc := range testcases {
+		actual, err := ParseStatusRanges(tc.statuses)
+
+		if err != nil {
+			t.Fatalf("Unexpected error has been raised. error: %s", err)
+		}
+
+		if !reflect.DeepEqual(actual, tc.expected) {
+			t.Fatalf("Status ranges do not match. statuses: %s, expected: %v, actual: %v", tc.statuses, tc.expected, actual)
+		}
+	}
+
+	for _, statuses := range []string{"", "ok", "6xx", "299-200", "99", "200-abc"} {
+		if _, err := ParseStatusRanges(statuses); err == nil {
+			t.Fatalf("Error should be raised with invalid statuses. statuses: %s", statuses)
+		}
+	}
+}
+
+func TestAcceptsStatus(t *testing.T) {
+	healthCheck := &HealthCheck{
+		Statuses: []StatusRange{{200, 299}, {304, 304}},
+	}
+
+	for code, expected := range map[int]bool{200: true, 204: true, 301: false, 304: true, 500: false} {
+		if actual := healthCheck.AcceptsStatus(code); actual != expected {
+			t.Fatalf("Result does not match. code: %d, expected: %t, actual: %t", code, expected, actual)
+		}
+	}
+}
@@ -0,0 +1,278 @@
+package model
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/dtan4/paus-gitreceive/receiver/store"
+	"github.com/pkg/errors"
+)
+
+// LockHolder is information of the process holding deploy lock, stored as the value of lock key
+type LockHolder struct {
+	Token       string    `json:"token"`
+	Pusher      string    `json:"pusher"`
+	Fingerprint string    `json:"fingerprint,omitempty"`
+	Branch      string    `json:"branch,omitempty"`
+	Revision    string    `json:"revision,omitempty"`
+	Host        string    `json:"host"`
+	PID         int       `json:"pid"`
+	AcquiredAt  time.Time `json:"acquired_at"`
+}
+
+// String returns human readable description of the holder
+func (h *LockHolder) String() string {
+	s := h.Pusher
+
+	if h.Branch != "" {
+		s += " (" + h.Branch
+
+		if len(h.Revision) >= 8 {
+			s += "@" + h.Revision[0:8]
+		}
+
+		s += ")"
+	}
+
+	return s + fmt.Sprintf(" on %s[%d] since %s", h.Host, h.PID, h.AcquiredAt.Format(time.RFC3339))
+}
+
+// DeployLock is a distributed lock per application, which serializes deploys of the same application.
+// Lock key expires after TTL, so that the lock held by a crashed process is released eventually.
+// While the lock is held, its TTL is refreshed periodically.
+type DeployLock struct {
+	app    *Application
+	holder *LockHolder
+	ttl    time.Duration
+	value  string
+
+	mu   sync.Mutex
+	stop chan struct{}
+	done chan struct{}
+	lost chan struct{}
+	now  func() time.Time
+}
+
+// NewLockHolder returns holder information of the deployment
+func NewLockHolder(deployment *Deployment) *LockHolder {
+	host, _ := os.Hostname()
+
+	return &LockHolder{
+		Pusher:      deployment.Pusher,
+		Fingerprint: deployment.Fingerprint,
+		Branch:      deployment.Branch,
+		Revision:    deployment.Revision,
+		Host:        host,
+		PID:         os.Getpid(),
+	}
+}
+
+func NewDeployLock(app *Application, holder *LockHolder, ttl time.Duration) *DeployLock {
+	return &DeployLock{
+		app:    app,
+		holder: holder,
+		ttl:    ttl,
+		now:    time.Now,
+	}
+}
+
+// lockKey returns the key of deploy lock of the application
+func lockKey(app *Application) string {
+	return "/paus/users/" + app.Username + "/apps/" + app.AppName + "/lock"
+}
+
+func newLockToken() (string, error) {
+	b := make([]byte, 16)
+
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "Failed to generate lock token.")
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// Holder returns information of the current lock holder, or nil if the lock is free
+func (l *DeployLock) Holder() (*LockHolder, error) {
+	key := lockKey(l.app)
+
+	if !l.app.store.HasKey(key) {
+		return nil, nil
+	}
+
+	value, err := l.app.store.Get(key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var holder LockHolder
+
+	if err := json.Unmarshal([]byte(value), &holder); err != nil {
+		return nil, errors.Wrapf(err, "Failed to parse deploy lock. key: %s", key)
+	}
+
+	return &holder, nil
+}
+
+// TryAcquire acquires the lock if it is free. It returns false if the lock is held by others.
+func (l *DeployLock) TryAcquire() (bool, error) {
+	token, err := newLockToken()
+
+	if err != nil {
+		return false, err
+	}
+
+	holder := *l.holder
+	holder.Token = token
+	holder.AcquiredAt = l.now()
+
+	b, err := json.Marshal(&holder)
+
+	if err != nil {
+		return false, errors.Wrap(err, "Failed to generate deploy lock JSON.")
+	}
+
+	if err := l.app.store.CreateWithTTL(lockKey(l.app), string(b), l.ttl); err != nil {
+		if errors.Cause(err) == store.ErrKeyExists {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.holder = &holder
+	l.value = string(b)
+	l.stop = make(chan struct{})
+	l.done = make(chan struct{})
+	l.lost = make(chan struct{})
+
+	go l.keepAlive(l.stop, l.done, l.lost)
+
+	return true, nil
+}
+
+// Acquire acquires the lock, polling every interval until timeout passes.
+// callback is called with the current holder every time the lock is found held by others.
+func (l *DeployLock) Acquire(timeout, interval time.Duration, callback func(holder *LockHolder)) error {
+	deadline := l.now().Add(timeout)
+
+	for {
+		acquired, err := l.TryAcquire()
+
+		if err != nil {
+			return err
+		}
+
+		if acquired {
+			return nil
+		}
+
+		holder, err := l.Holder()
+
+		if err != nil {
+			return err
+		}
+
+		if holder != nil {
+			callback(holder)
+		}
+
+		if !l.now().Before(deadline) {
+			if holder == nil {
+				return errors.Errorf("Timed out waiting for deploy lock of %s.", l.app.Repository)
+			}
+
+			return errors.Errorf("Timed out waiting for deploy lock of %s. holder: %s", l.app.Repository, holder)
+		}
+
+		time.Sleep(interval)
+	}
+}
+
+// keepAlive refreshes TTL of the lock until stop is closed.
+// lost is closed when the lock is taken over or deleted, or is not refreshed within TTL, because another deploy may hold it then.
+func (l *DeployLock) keepAlive(stop <-chan struct{}, done chan<- struct{}, lost chan<- struct{}) {
+	defer close(done)
+
+	if l.ttl == 0 {
+		<-stop
+		return
+	}
+
+	ticker := time.NewTicker(l.ttl / 3)
+	defer ticker.Stop()
+
+	refreshedAt := l.now()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			err := l.app.store.CompareAndSwap(lockKey(l.app), l.value, l.value, l.ttl)
+
+			if err == nil {
+				refreshedAt = l.now()
+				continue
+			}
+
+			fmt.Fprintf(os.Stderr, "=====> Failed to refresh deploy lock. error: %s\n", err)
+
+			if errors.Cause(err) == store.ErrCompareFailed || l.now().Sub(refreshedAt) >= l.ttl {
+				close(lost)
+				<-stop
+				return
+			}
+		}
+	}
+}
+
+// Lost returns channel which is closed when the lock is lost while it is held
+func (l *DeployLock) Lost() <-chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.lost
+}
+
+// Check returns error if the lock has been lost, so that deploy is aborted before racing with another deploy
+func (l *DeployLock) Check() error {
+	select {
+	case <-l.Lost():
+		return errors.Errorf("Deploy lock of %s was lost. Another deploy may be in progress.", l.app.Repository)
+	default:
+		return nil
+	}
+}
+
+// Release releases the lock if it is held by this process. It is safe to call Release more than once.
+func (l *DeployLock) Release() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.stop == nil {
+		return nil
+	}
+
+	close(l.stop)
+	<-l.done
+	l.stop = nil
+
+	if err := l.app.store.CompareAndDelete(lockKey(l.app), l.value); err != nil {
+		if errors.Cause(err) == store.ErrCompareFailed {
+			return errors.Errorf("Deploy lock of %s was lost before release.", l.app.Repository)
+		}
+
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,128 @@
+package model
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dtan4/paus-gitreceive/receiver/store"
+)
+
+func TestDeployLock(t *testing.T) {
+	memory := store.NewMemory()
+	app := &Application{
+		Repository: "dtan4-app",
+		Username:   "dtan4",
+		AppName:    "app",
+		store:      memory,
+	}
+
+	deployment := NewDeployment(app, "master", "19fb23cd71a4cf2eab00ad1a393e40de4ed61531", "1467181319", "/repos")
+	deployment.Pusher = "dtan4"
+
+	lock1 := NewDeployLock(app, NewLockHolder(deployment), time.Minute)
+	lock2 := NewDeployLock(app, &LockHolder{Pusher: "alice"}, time.Minute)
+
+	acquired, err := lock1.TryAcquire()
+
+	if err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	if !acquired {
+		t.Fatalf("Free lock should be acquired.")
+	}
+
+	acquired, err = lock2.TryAcquire()
+
+	if err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	if acquired {
+		t.Fatalf("Lock held by others should not be acquired.")
+	}
+
+	holder, err := lock2.Holder()
+
+	if err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	if holder.Pusher != "dtan4" || holder.Branch != "master" || holder.Token == "" {
+		t.Fatalf("Holder does not match. holder: %+v", holder)
+	}
+
+	if !strings.HasPrefix(holder.String(), "dtan4 (master@19fb23cd) on ") {
+		t.Fatalf("Holder description does not match. actual: %s", holder)
+	}
+
+	called := 0
+	err = lock2.Acquire(0, time.Millisecond, func(h *LockHolder) {
+		called++
+	})
+
+	if err == nil {
+		t.Fatalf("Error should be raised when waiting for lock times out.")
+	}
+
+	if called != 1 {
+		t.Fatalf("Callback should be called with the holder. called: %d", called)
+	}
+
+	if err := lock2.Release(); err != nil {
+		t.Fatalf("Releasing lock not acquired should be no-op. error: %s", err)
+	}
+
+	if err := lock1.Release(); err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	if err := lock1.Release(); err != nil {
+		t.Fatalf("Releasing lock twice should be no-op. error: %s", err)
+	}
+
+	if err := lock2.Acquire(time.Second, time.Millisecond, func(h *LockHolder) {}); err != nil {
+		t.Fatalf("Released lock should be acquired. error: %s", err)
+	}
+
+	memory.Delete("/paus/users/dtan4/apps/app/lock")
+
+	if err := lock2.Release(); err == nil {
+		t.Fatalf("Error should be raised when lock was lost.")
+	}
+}
+
+func TestDeployLockLost(t *testing.T) {
+	memory := store.NewMemory()
+	app := &Application{
+		Repository: "dtan4-app",
+		Username:   "dtan4",
+		AppName:    "app",
+		store:      memory,
+	}
+
+	lock := NewDeployLock(app, &LockHolder{Pusher: "dtan4"}, 30*time.Millisecond)
+
+	if err := lock.Acquire(time.Second, time.Millisecond, func(h *LockHolder) {}); err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	defer lock.Release()
+
+	if err := lock.Check(); err != nil {
+		t.Fatalf("Held lock should not be lost. error: %s", err)
+	}
+
+	memory.Delete("/paus/users/dtan4/apps/app/lock")
+
+	select {
+	case <-lock.Lost():
+	case <-time.After(time.Second):
+		t.Fatalf("Lost lock should be signaled.")
+	}
+
+	if err := lock.Check(); err == nil {
+		t.Fatalf("Error should be raised when lock was lost.")
+	}
+}
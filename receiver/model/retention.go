@@ -0,0 +1,81 @@
+package model
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// RetentionPolicy decides which deployments are kept, per branch.
+// Zero MaxPerBranch or MaxAge means no limit.
+type RetentionPolicy struct {
+	MaxAge            int64
+	MaxPerBranch      int64
+	ProtectedBranches []string
+}
+
+// ParseBranches parses comma-separated branch names
+func ParseBranches(branches string) []string {
+	result := []string{}
+
+	for _, branch := range strings.Split(branches, ",") {
+		if branch = strings.TrimSpace(branch); branch != "" {
+			result = append(result, branch)
+		}
+	}
+
+	return result
+}
+
+func (p *RetentionPolicy) isProtected(branch string) bool {
+	for _, b := range p.ProtectedBranches {
+		if b == branch {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Evict returns timestamps of deployments to remove, in ascending order.
+// deployments is a map of timestamp and branch of each deployment. Branch is empty if it is unknown.
+// The newest deployment of every protected branch is always kept.
+func (p *RetentionPolicy) Evict(deployments map[string]string, now time.Time) ([]string, error) {
+	branches := map[string][]string{}
+
+	for timestamp, branch := range deployments {
+		branches[branch] = append(branches[branch], timestamp)
+	}
+
+	evicted := []string{}
+
+	for branch, timestamps := range branches {
+		sort.Sort(sort.Reverse(sort.StringSlice(timestamps)))
+
+		for i, timestamp := range timestamps {
+			if i == 0 && p.isProtected(branch) {
+				continue
+			}
+
+			deployedAt, err := strconv.ParseInt(timestamp, 10, 64)
+
+			if err != nil {
+				return nil, errors.Wrapf(err, "Invalid deployment timestamp. timestamp: %s", timestamp)
+			}
+
+			tooMany := p.MaxPerBranch > 0 && int64(i) >= p.MaxPerBranch
+			tooOld := p.MaxAge > 0 && now.Unix()-deployedAt > p.MaxAge
+
+			if tooMany || tooOld {
+				evicted = append(evicted, timestamp)
+			}
+		}
+	}
+
+	sort.Strings(evicted)
+
+	return evicted, nil
+}
@@ -0,0 +1,70 @@
+package model
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseBranches(t *testing.T) {
+	expected := []string{"master", "production"}
+	actual := ParseBranches(" master, production,,")
+
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("Branches do not match. expected: %v, actual: %v", expected, actual)
+	}
+}
+
+func TestEvict(t *testing.T) {
+	now := time.Unix(1467181400, 0)
+	deployments := map[string]string{
+		"1467100000": "master",
+		"1467181319": "feature",
+		"1467181320": "feature",
+		"1467181321": "feature",
+		"1467181322": "feature",
+		"1467181323": "",
+	}
+
+	testcases := []struct {
+		policy   *RetentionPolicy
+		expected []string
+	}{
+		{
+			&RetentionPolicy{},
+			[]string{},
+		},
+		{
+			&RetentionPolicy{MaxPerBranch: 2, ProtectedBranches: []string{"master"}},
+			[]string{"1467181319", "1467181320"},
+		},
+		{
+			&RetentionPolicy{MaxAge: 3600, ProtectedBranches: []string{"master"}},
+			[]string{},
+		},
+		{
+			&RetentionPolicy{MaxAge: 3600},
+			[]string{"1467100000"},
+		},
+		{
+			&RetentionPolicy{MaxPerBranch: 1, MaxAge: 3600, ProtectedBranches: []string{"master"}},
+			[]string{"1467181319", "1467181320", "1467181321"},
+		},
+	}
+
+	for _, tc := range testcases {
+		actual, err := tc.policy.Evict(deployments, now)
+
+		if err != nil {
+			t.Fatalf("Unexpected error has been raised. error: %s", err)
+		}
+
+		if !reflect.DeepEqual(actual, tc.expected) {
+			t.Fatalf("Evicted deployments do not match. policy: %+v, expected: %v, actual: %v", tc.policy, tc.expected, actual)
+		}
+	}
+
+	if _, err := (&RetentionPolicy{MaxAge: 3600}).Evict(map[string]string{"invalid": "master"}, now); err == nil {
+		t.Fatalf("Error should be raised with invalid timestamp.")
+	}
+}
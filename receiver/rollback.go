@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/dtan4/paus-gitreceive/receiver/config"
+	"github.com/dtan4/paus-gitreceive/receiver/model"
+	"github.com/dtan4/paus-gitreceive/receiver/store"
+	"github.com/dtan4/paus-gitreceive/receiver/vulcand"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultRollbackBranch = "master"
+	rollbackUsage         = "Usage: receiver rollback <user/app> <revision|timestamp> [branch]"
+)
+
+// args: user/app, 19fb23cd|1467181319, [branch-name]
+func rollback(config *config.Config, st store.Store, args []string) error {
+	if len(args) < 2 {
+		return errors.New(rollbackUsage)
+	}
+
+	application, err := model.ApplicationFromRepository(args[0], st)
+
+	if err != nil {
+		return err
+	}
+
+	if !application.DirExists() {
+		return errors.Errorf("Application not found: %s", application.AppName)
+	}
+
+	timestamp, revision, err := application.FindDeployment(args[1])
+
+	if err != nil {
+		return err
+	}
+
+	deployment, err := model.LoadDeployment(application, timestamp, config.RepositoryDir)
+
+	if err != nil {
+		return err
+	}
+
+	branch, err := rollbackBranch(deployment, args)
+
+	if err != nil {
+		return err
+	}
+
+	deployment.Branch = branch
+
+	holder := model.NewLockHolder(deployment)
+	holder.Pusher = "rollback"
+
+	lock, err := acquireDeployLock(config, application, holder)
+
+	if err != nil {
+		return err
+	}
+
+	defer releaseDeployLock(lock)
+
+	fmt.Println("=====> Rolling back " + branch + " to " + revision + " (deployed at " + timestamp + ") ...")
+
+	compose, err := model.NewCompose(config.DockerHost, deployment.ComposeFilePath, deployment.ProjectName)
+
+	if err != nil {
+		return err
+	}
+
+	webPort, scale, err := rollbackWebService(application, compose, deployment)
+
+	if err != nil {
+		return err
+	}
+
+	// Secrets are not saved in compose file, so that current secrets are passed again
+	if err := injectSecrets(application, compose); err != nil {
+		return err
+	}
+
+	fmt.Println("=====> Starting containers ...")
+
+	if err := compose.Up(); err != nil {
+		return err
+	}
+
+	webContainerIDs, err := scaleWebService(compose, scale)
+
+	if err != nil {
+		return stopRollback(st, deployment, compose, err)
+	}
+
+	webContainers, err := webContainers(config.DockerHost, webContainerIDs, webPort)
+
+	if err != nil {
+		return stopRollback(st, deployment, compose, err)
+	}
+
+	healthy, err := healthCheck(config, application, compose, webContainers)
+
+	if err == nil && !healthy {
+		err = errors.New("Web container is not active. Aborted.")
+	}
+
+	if err != nil {
+		return stopRollback(st, deployment, compose, err)
+	}
+
+	routeContainers, err := routeContainers(compose, config.DockerHost)
+
+	if err != nil {
+		return stopRollback(st, deployment, compose, err)
+	}
+
+	if err := lock.Check(); err != nil {
+		return stopRollback(st, deployment, compose, err)
+	}
+
+	fmt.Println("=====> Rewriting routing information ...")
+
+	tx := store.NewTransaction(st)
+
+	identifiers, err := vulcand.RegisterInformation(tx, deployment, config.BaseDomain, webContainers, routeContainers)
+
+	if err == nil {
+		err = application.WithStore(tx).SetDeploymentStatus(timestamp, model.DeploymentStatusActive)
+	}
+
+	if err != nil {
+		if e := tx.Rollback(); e != nil {
+			return errors.Wrapf(e, "Failed to roll back routing information. cause: %v", err)
+		}
+
+		return err
+	}
+
+	tx.Commit()
+
+	printDeployedURLs(application.Repository, config, identifiers)
+
+	return nil
+}
+
+// rollbackBranch returns the branch which the deployment was deployed to.
+// Branch argument is used only for old records without branch.
+func rollbackBranch(deployment *model.Deployment, args []string) (string, error) {
+	if deployment.Branch != "" {
+		if len(args) > 2 && args[2] != deployment.Branch {
+			return "", errors.Errorf("Deployment %s was deployed to %s, not %s.", deployment.Revision, deployment.Branch, args[2])
+		}
+
+		return deployment.Branch, nil
+	}
+
+	if len(args) > 2 {
+		return args[2], nil
+	}
+
+	return defaultRollbackBranch, nil
+}
+
+// rollbackWebService returns web port and scale recorded at deploy, so that the same containers are routed again.
+// Current app settings are used only for old records without them.
+func rollbackWebService(application *model.Application, compose *model.Compose, deployment *model.Deployment) (string, int, error) {
+	if deployment.WebService != "" {
+		compose.WebService = deployment.WebService
+
+		return deployment.WebPort, deployment.Scale, nil
+	}
+
+	webPort, err := configureWebService(application, compose)
+
+	if err != nil {
+		return "", 0, err
+	}
+
+	scale, err := application.Scale()
+
+	if err != nil {
+		return "", 0, err
+	}
+
+	return webPort, scale, nil
+}
+
+// stopRollback stops containers started by rollback, unless the deployment is still routed from other frontends
+func stopRollback(st store.Store, deployment *model.Deployment, compose *model.Compose, cause error) error {
+	routed, err := vulcand.IsRouted(st, deployment)
+
+	if err != nil {
+		return errors.Wrapf(err, "Failed to check routing of the deployment. cause: %v", cause)
+	}
+
+	if !routed {
+		compose.Stop()
+	}
+
+	return cause
+}
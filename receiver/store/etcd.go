@@ -1,6 +1,8 @@
 package store
 
 import (
+	"time"
+
 	"github.com/coreos/etcd/client"
 	"github.com/pkg/errors"
 	"golang.org/x/net/context"
@@ -27,6 +29,56 @@ func NewEtcd(etcdEndpoint string) (*Etcd, error) {
 	return &Etcd{keysAPI}, nil
 }
 
+// errorWithCode converts etcd error of the code into cause, so that callers can distinguish it
+func errorWithCode(err error, code int, cause error) error {
+	if e, ok := err.(client.Error); ok && e.Code == code {
+		return cause
+	}
+
+	return err
+}
+
+func (c *Etcd) CompareAndDelete(key, prevValue string) error {
+	_, err := c.keysAPI.Delete(context.Background(), key, &client.DeleteOptions{
+		PrevValue: prevValue,
+	})
+
+	if err != nil {
+		err = errorWithCode(errorWithCode(err, client.ErrorCodeTestFailed, ErrCompareFailed), client.ErrorCodeKeyNotFound, ErrCompareFailed)
+		return errors.Wrapf(err, "Failed to delete etcd entry. key: %s", key)
+	}
+
+	return nil
+}
+
+func (c *Etcd) CompareAndSwap(key, prevValue, value string, ttl time.Duration) error {
+	_, err := c.keysAPI.Set(context.Background(), key, value, &client.SetOptions{
+		PrevValue: prevValue,
+		PrevExist: client.PrevExist,
+		TTL:       ttl,
+	})
+
+	if err != nil {
+		err = errorWithCode(errorWithCode(err, client.ErrorCodeTestFailed, ErrCompareFailed), client.ErrorCodeKeyNotFound, ErrCompareFailed)
+		return errors.Wrapf(err, "Failed to swap etcd value. key: %s", key)
+	}
+
+	return nil
+}
+
+func (c *Etcd) CreateWithTTL(key, value string, ttl time.Duration) error {
+	_, err := c.keysAPI.Set(context.Background(), key, value, &client.SetOptions{
+		PrevExist: client.PrevNoExist,
+		TTL:       ttl,
+	})
+
+	if err != nil {
+		return errors.Wrapf(errorWithCode(err, client.ErrorCodeNodeExist, ErrKeyExists), "Failed to create etcd entry. key: %s", key)
+	}
+
+	return nil
+}
+
 func (c *Etcd) Delete(key string) error {
 	_, err := c.keysAPI.Delete(context.Background(), key, &client.DeleteOptions{})
 
@@ -66,6 +118,16 @@ func (c *Etcd) HasKey(key string) bool {
 	return err == nil
 }
 
+func (c *Etcd) IsDir(key string) (bool, error) {
+	resp, err := c.keysAPI.Get(context.Background(), key, &client.GetOptions{})
+
+	if err != nil {
+		return false, errors.Wrapf(err, "Failed to get etcd entry. key: %s", key)
+	}
+
+	return resp.Node.Dir, nil
+}
+
 func (c *Etcd) List(key string, recursive bool) ([]string, error) {
 	result := []string{}
 
@@ -86,10 +148,13 @@ func (c *Etcd) List(key string, recursive bool) ([]string, error) {
 }
 
 func (c *Etcd) Mkdir(key string) error {
-	_, err := c.keysAPI.Set(context.Background(), key, "", &client.SetOptions{Dir: true})
+	_, err := c.keysAPI.Set(context.Background(), key, "", &client.SetOptions{
+		Dir:       true,
+		PrevExist: client.PrevNoExist,
+	})
 
 	if err != nil {
-		return errors.Wrapf(err, "Failed to create etcd directory. key: %s", key)
+		return errors.Wrapf(errorWithCode(err, client.ErrorCodeNodeExist, ErrKeyExists), "Failed to create etcd directory. key: %s", key)
 	}
 
 	return nil
@@ -104,3 +169,13 @@ func (c *Etcd) Set(key, value string) error {
 
 	return nil
 }
+
+func (c *Etcd) TTL(key string) (time.Duration, error) {
+	resp, err := c.keysAPI.Get(context.Background(), key, &client.GetOptions{})
+
+	if err != nil {
+		return 0, errors.Wrapf(err, "Failed to get etcd TTL. key: %s", key)
+	}
+
+	return time.Duration(resp.Node.TTL) * time.Second, nil
+}
@@ -0,0 +1,127 @@
+package store
+
+import (
+	"path"
+	"time"
+
+	"github.com/coreos/etcd/client"
+	"golang.org/x/net/context"
+)
+
+type fakeNode struct {
+	dir   bool
+	ttl   time.Duration
+	value string
+}
+
+// fakeKeysAPI imitates etcd v2 keyspace in memory. Only Get, Set and Delete are implemented.
+// TTL is recorded, but keys never expire.
+type fakeKeysAPI struct {
+	client.KeysAPI
+	nodes map[string]*fakeNode
+}
+
+func newFakeKeysAPI() *fakeKeysAPI {
+	return &fakeKeysAPI{
+		nodes: map[string]*fakeNode{
+			rootKey: {dir: true},
+		},
+	}
+}
+
+func fakeError(code int, key string) error {
+	return client.Error{Code: code, Message: key}
+}
+
+func (k *fakeKeysAPI) children(key string) []string {
+	keys := []string{}
+
+	for nodeKey := range k.nodes {
+		keys = append(keys, nodeKey)
+	}
+
+	return immediateChildren(key, keys)
+}
+
+func (k *fakeKeysAPI) Get(ctx context.Context, key string, opts *client.GetOptions) (*client.Response, error) {
+	key = normalizeKey(key)
+	node, ok := k.nodes[key]
+
+	if !ok {
+		return nil, fakeError(client.ErrorCodeKeyNotFound, key)
+	}
+
+	resp := &client.Response{
+		Node: &client.Node{Key: key, Dir: node.dir, Value: node.value, TTL: int64(node.ttl / time.Second)},
+	}
+
+	for _, child := range k.children(key) {
+		resp.Node.Nodes = append(resp.Node.Nodes, &client.Node{Key: child, Dir: k.nodes[child].dir, Value: k.nodes[child].value})
+	}
+
+	return resp, nil
+}
+
+func (k *fakeKeysAPI) Set(ctx context.Context, key, value string, opts *client.SetOptions) (*client.Response, error) {
+	key = normalizeKey(key)
+	node, ok := k.nodes[key]
+
+	switch {
+	case ok && opts.PrevExist == client.PrevNoExist:
+		return nil, fakeError(client.ErrorCodeNodeExist, key)
+	case !ok && opts.PrevExist == client.PrevExist:
+		return nil, fakeError(client.ErrorCodeKeyNotFound, key)
+	case ok && node.dir:
+		return nil, fakeError(client.ErrorCodeNotFile, key)
+	case opts.PrevValue != "" && (!ok || node.value != opts.PrevValue):
+		return nil, fakeError(client.ErrorCodeTestFailed, key)
+	}
+
+	for parent := path.Dir(key); parent != rootKey; parent = path.Dir(parent) {
+		if node, ok := k.nodes[parent]; ok && !node.dir {
+			return nil, fakeError(client.ErrorCodeNotDir, parent)
+		}
+	}
+
+	for parent := path.Dir(key); parent != rootKey; parent = path.Dir(parent) {
+		if _, ok := k.nodes[parent]; !ok {
+			k.nodes[parent] = &fakeNode{dir: true}
+		}
+	}
+
+	if opts.Dir {
+		k.nodes[key] = &fakeNode{dir: true}
+	} else {
+		k.nodes[key] = &fakeNode{value: value, ttl: opts.TTL}
+	}
+
+	return &client.Response{Node: &client.Node{Key: key, Dir: opts.Dir, Value: value}}, nil
+}
+
+func (k *fakeKeysAPI) deleteTree(key string) {
+	for _, child := range k.children(key) {
+		k.deleteTree(child)
+	}
+
+	delete(k.nodes, key)
+}
+
+func (k *fakeKeysAPI) Delete(ctx context.Context, key string, opts *client.DeleteOptions) (*client.Response, error) {
+	key = normalizeKey(key)
+	node, ok := k.nodes[key]
+
+	switch {
+	case !ok:
+		return nil, fakeError(client.ErrorCodeKeyNotFound, key)
+	case node.dir && !opts.Dir:
+		return nil, fakeError(client.ErrorCodeNotFile, key)
+	case node.dir && !opts.Recursive && len(k.children(key)) > 0:
+		return nil, fakeError(client.ErrorCodeDirNotEmpty, key)
+	case opts.PrevValue != "" && node.value != opts.PrevValue:
+		return nil, fakeError(client.ErrorCodeTestFailed, key)
+	}
+
+	k.deleteTree(key)
+
+	return &client.Response{Node: &client.Node{Key: key, Dir: node.dir}}, nil
+}
@@ -0,0 +1,596 @@
+package store
+
+import (
+	"path"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+const (
+	etcdV3DialTimeout = 5 * time.Second
+)
+
+// EtcdV3 emulates etcd v2 directories over etcd v3 flat keyspace.
+// Directory is represented as a marker key with trailing slash (e.g. /paus/users/dtan4/),
+// and its children are keys which have the marker key as prefix.
+type EtcdV3 struct {
+	kv etcdV3KV
+}
+
+// etcdV3KV is the flat keyspace operations which EtcdV3 emulates directories on
+type etcdV3KV interface {
+	// count returns the number of the key, or keys which have the key as prefix if prefix is true
+	count(key string, prefix bool) (int64, error)
+	// compareAndDelete deletes the key only if it has prevValue
+	compareAndDelete(key, prevValue string) (bool, error)
+	// compareAndPut sets value only if the key has prevValue
+	compareAndPut(key, prevValue, value string, ttl time.Duration) (bool, error)
+	// create sets value only if the key does not exist
+	create(key, value string, ttl time.Duration) (bool, error)
+	// delete deletes the key, or keys which have the key as prefix if prefix is true, and returns the number of deleted keys
+	delete(key string, prefix bool) (int64, error)
+	// get returns value of the key, and whether the key exists
+	get(key string) (string, bool, error)
+	// keys returns keys which have prefix
+	keys(prefix string) ([]string, error)
+	put(key, value string) error
+	// ttl returns the remaining time until the key expires, or 0 if the key does not have a lease
+	ttl(key string) (time.Duration, error)
+}
+
+type clientV3KV struct {
+	client *clientv3.Client
+}
+
+func NewEtcdV3(etcdEndpoint string) (*EtcdV3, error) {
+	config := clientv3.Config{
+		Endpoints:   []string{etcdEndpoint},
+		DialTimeout: etcdV3DialTimeout,
+	}
+
+	c, err := clientv3.New(config)
+
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to create etcd v3 client.")
+	}
+
+	return &EtcdV3{&clientV3KV{c}}, nil
+}
+
+func dirKey(key string) string {
+	if key == rootKey {
+		return rootKey
+	}
+
+	return key + "/"
+}
+
+func prefixOption(prefix bool) []clientv3.OpOption {
+	if prefix {
+		return []clientv3.OpOption{clientv3.WithPrefix()}
+	}
+
+	return []clientv3.OpOption{}
+}
+
+// grant returns a new lease of ttl
+func (kv *clientV3KV) grant(ttl time.Duration) (clientv3.LeaseID, error) {
+	seconds := int64(ttl / time.Second)
+
+	if seconds < 1 {
+		seconds = 1
+	}
+
+	resp, err := kv.client.Grant(context.Background(), seconds)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return resp.ID, nil
+}
+
+// revoke revokes lease which is not attached to any key. Failure is ignored, because the lease expires anyway.
+func (kv *clientV3KV) revoke(lease clientv3.LeaseID) {
+	kv.client.Revoke(context.Background(), lease)
+}
+
+// leaseOf returns lease attached to the key, or 0 if the key does not exist or has no lease
+func (kv *clientV3KV) leaseOf(key string) (clientv3.LeaseID, error) {
+	resp, err := kv.client.Get(context.Background(), key)
+
+	if err != nil {
+		return 0, err
+	}
+
+	if len(resp.Kvs) == 0 {
+		return 0, nil
+	}
+
+	return clientv3.LeaseID(resp.Kvs[0].Lease), nil
+}
+
+// putIf sets value only if cmp succeeds
+func (kv *clientV3KV) putIf(cmp clientv3.Cmp, key, value string, opts ...clientv3.OpOption) (bool, error) {
+	resp, err := kv.client.Txn(context.Background()).
+		If(cmp).
+		Then(clientv3.OpPut(key, value, opts...)).
+		Commit()
+
+	if err != nil {
+		return false, err
+	}
+
+	return resp.Succeeded, nil
+}
+
+// putIfWithNewLease sets value with a new lease of ttl only if cmp succeeds. The lease is revoked if value is not set.
+func (kv *clientV3KV) putIfWithNewLease(cmp clientv3.Cmp, key, value string, ttl time.Duration) (bool, error) {
+	lease, err := kv.grant(ttl)
+
+	if err != nil {
+		return false, err
+	}
+
+	succeeded, err := kv.putIf(cmp, key, value, clientv3.WithLease(lease))
+
+	if err != nil || !succeeded {
+		kv.revoke(lease)
+	}
+
+	return succeeded, err
+}
+
+func (kv *clientV3KV) count(key string, prefix bool) (int64, error) {
+	resp, err := kv.client.Get(context.Background(), key, append(prefixOption(prefix), clientv3.WithCountOnly())...)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return resp.Count, nil
+}
+
+func (kv *clientV3KV) compareAndDelete(key, prevValue string) (bool, error) {
+	resp, err := kv.client.Txn(context.Background()).
+		If(clientv3.Compare(clientv3.Value(key), "=", prevValue)).
+		Then(clientv3.OpDelete(key)).
+		Commit()
+
+	if err != nil {
+		return false, err
+	}
+
+	return resp.Succeeded, nil
+}
+
+// compareAndPut reuses and refreshes existing lease of the key, so that refreshing the key does not grant a new lease every time
+func (kv *clientV3KV) compareAndPut(key, prevValue, value string, ttl time.Duration) (bool, error) {
+	cmp := clientv3.Compare(clientv3.Value(key), "=", prevValue)
+
+	if ttl == 0 {
+		return kv.putIf(cmp, key, value)
+	}
+
+	lease, err := kv.leaseOf(key)
+
+	if err != nil {
+		return false, err
+	}
+
+	if lease == 0 {
+		return kv.putIfWithNewLease(cmp, key, value, ttl)
+	}
+
+	succeeded, err := kv.putIf(cmp, key, value, clientv3.WithLease(lease))
+
+	if err != nil || !succeeded {
+		return succeeded, err
+	}
+
+	if _, err := kv.client.KeepAliveOnce(context.Background(), lease); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (kv *clientV3KV) create(key, value string, ttl time.Duration) (bool, error) {
+	cmp := clientv3.Compare(clientv3.CreateRevision(key), "=", 0)
+
+	if ttl == 0 {
+		return kv.putIf(cmp, key, value)
+	}
+
+	return kv.putIfWithNewLease(cmp, key, value, ttl)
+}
+
+func (kv *clientV3KV) delete(key string, prefix bool) (int64, error) {
+	resp, err := kv.client.Delete(context.Background(), key, prefixOption(prefix)...)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return resp.Deleted, nil
+}
+
+func (kv *clientV3KV) get(key string) (string, bool, error) {
+	resp, err := kv.client.Get(context.Background(), key)
+
+	if err != nil {
+		return "", false, err
+	}
+
+	if len(resp.Kvs) == 0 {
+		return "", false, nil
+	}
+
+	return string(resp.Kvs[0].Value), true, nil
+}
+
+func (kv *clientV3KV) keys(prefix string) ([]string, error) {
+	resp, err := kv.client.Get(context.Background(), prefix, clientv3.WithPrefix(), clientv3.WithKeysOnly())
+
+	if err != nil {
+		return nil, err
+	}
+
+	keys := []string{}
+
+	for _, kv := range resp.Kvs {
+		keys = append(keys, string(kv.Key))
+	}
+
+	return keys, nil
+}
+
+func (kv *clientV3KV) put(key, value string) error {
+	_, err := kv.client.Put(context.Background(), key, value)
+
+	return err
+}
+
+func (kv *clientV3KV) ttl(key string) (time.Duration, error) {
+	leaseID, err := kv.leaseOf(key)
+
+	if err != nil {
+		return 0, err
+	}
+
+	if leaseID == 0 {
+		return 0, nil
+	}
+
+	lease, err := kv.client.TimeToLive(context.Background(), leaseID)
+
+	if err != nil {
+		return 0, err
+	}
+
+	// Expired lease has negative TTL
+	if lease.TTL < 0 {
+		return 0, nil
+	}
+
+	return time.Duration(lease.TTL) * time.Second, nil
+}
+
+func (c *EtcdV3) isDir(key string) (bool, error) {
+	n, err := c.kv.count(dirKey(key), true)
+
+	if err != nil {
+		return false, err
+	}
+
+	return n > 0, nil
+}
+
+func (c *EtcdV3) isFile(key string) (bool, error) {
+	n, err := c.kv.count(key, false)
+
+	if err != nil {
+		return false, err
+	}
+
+	return n > 0, nil
+}
+
+// checkParents returns error if any parent of key is a file, because etcd v2 does not create a key under a file
+func (c *EtcdV3) checkParents(key string) error {
+	for parent := path.Dir(key); parent != rootKey; parent = path.Dir(parent) {
+		isFile, err := c.isFile(parent)
+
+		if err != nil {
+			return err
+		}
+
+		if isFile {
+			return errors.Errorf("Not a directory. key: %s", parent)
+		}
+	}
+
+	return nil
+}
+
+func (c *EtcdV3) CompareAndDelete(key, prevValue string) error {
+	key = normalizeKey(key)
+
+	succeeded, err := c.kv.compareAndDelete(key, prevValue)
+
+	if err != nil {
+		return errors.Wrapf(err, "Failed to delete etcd entry. key: %s", key)
+	}
+
+	if !succeeded {
+		return errors.Wrapf(ErrCompareFailed, "Failed to delete etcd entry. key: %s", key)
+	}
+
+	return nil
+}
+
+func (c *EtcdV3) CompareAndSwap(key, prevValue, value string, ttl time.Duration) error {
+	key = normalizeKey(key)
+
+	succeeded, err := c.kv.compareAndPut(key, prevValue, value, ttl)
+
+	if err != nil {
+		return errors.Wrapf(err, "Failed to swap etcd value. key: %s", key)
+	}
+
+	if !succeeded {
+		return errors.Wrapf(ErrCompareFailed, "Failed to swap etcd value. key: %s", key)
+	}
+
+	return nil
+}
+
+func (c *EtcdV3) CreateWithTTL(key, value string, ttl time.Duration) error {
+	key = normalizeKey(key)
+
+	if err := c.checkParents(key); err != nil {
+		return errors.Wrapf(err, "Failed to create etcd entry. key: %s", key)
+	}
+
+	succeeded, err := c.kv.create(key, value, ttl)
+
+	if err != nil {
+		return errors.Wrapf(err, "Failed to create etcd entry. key: %s", key)
+	}
+
+	if !succeeded {
+		return errors.Wrapf(ErrKeyExists, "Failed to create etcd entry. key: %s", key)
+	}
+
+	return nil
+}
+
+func (c *EtcdV3) Delete(key string) error {
+	key = normalizeKey(key)
+
+	isDir, err := c.isDir(key)
+
+	if err != nil {
+		return errors.Wrapf(err, "Failed to delete etcd entry. key: %s", key)
+	}
+
+	if isDir {
+		return errors.Errorf("Failed to delete etcd entry. key: %s: Not a file", key)
+	}
+
+	deleted, err := c.kv.delete(key, false)
+
+	if err != nil {
+		return errors.Wrapf(err, "Failed to delete etcd entry. key: %s", key)
+	}
+
+	if deleted == 0 {
+		return errors.Errorf("Failed to delete etcd entry. key: %s: Key not found", key)
+	}
+
+	return nil
+}
+
+func (c *EtcdV3) DeleteDir(key string, recursive bool) error {
+	key = normalizeKey(key)
+
+	if key == rootKey {
+		return errors.Errorf("Failed to delete etcd directory. key: %s, recursive: %t: Root is read only", key, recursive)
+	}
+
+	if !recursive {
+		children, err := c.List(key, false)
+
+		if err != nil {
+			return errors.Wrapf(err, "Failed to delete etcd directory. key: %s, recursive: %t", key, recursive)
+		}
+
+		if len(children) > 0 {
+			return errors.Errorf("Failed to delete etcd directory. key: %s, recursive: %t: Directory not empty", key, recursive)
+		}
+	}
+
+	dirDeleted, err := c.kv.delete(dirKey(key), true)
+
+	if err != nil {
+		return errors.Wrapf(err, "Failed to delete etcd directory. key: %s, recursive: %t", key, recursive)
+	}
+
+	// etcd v2 deletes a file even if it is deleted as directory
+	fileDeleted, err := c.kv.delete(key, false)
+
+	if err != nil {
+		return errors.Wrapf(err, "Failed to delete etcd directory. key: %s, recursive: %t", key, recursive)
+	}
+
+	if dirDeleted == 0 && fileDeleted == 0 {
+		return errors.Errorf("Failed to delete etcd directory. key: %s, recursive: %t: Key not found", key, recursive)
+	}
+
+	return nil
+}
+
+func (c *EtcdV3) Get(key string) (string, error) {
+	key = normalizeKey(key)
+
+	value, ok, err := c.kv.get(key)
+
+	if err != nil {
+		return "", errors.Wrapf(err, "Failed to get etcd value. key: %s", key)
+	}
+
+	if ok {
+		return value, nil
+	}
+
+	isDir, err := c.isDir(key)
+
+	if err != nil {
+		return "", errors.Wrapf(err, "Failed to get etcd value. key: %s", key)
+	}
+
+	if !isDir {
+		return "", errors.Errorf("Failed to get etcd value. key: %s: Key not found", key)
+	}
+
+	return "", nil
+}
+
+func (c *EtcdV3) HasKey(key string) bool {
+	key = normalizeKey(key)
+
+	if key == rootKey {
+		return true
+	}
+
+	if isFile, err := c.isFile(key); err == nil && isFile {
+		return true
+	}
+
+	isDir, err := c.isDir(key)
+
+	return err == nil && isDir
+}
+
+func (c *EtcdV3) IsDir(key string) (bool, error) {
+	key = normalizeKey(key)
+
+	if key == rootKey {
+		return true, nil
+	}
+
+	isFile, err := c.isFile(key)
+
+	if err != nil {
+		return false, errors.Wrapf(err, "Failed to get etcd entry. key: %s", key)
+	}
+
+	if isFile {
+		return false, nil
+	}
+
+	isDir, err := c.isDir(key)
+
+	if err != nil {
+		return false, errors.Wrapf(err, "Failed to get etcd entry. key: %s", key)
+	}
+
+	if !isDir {
+		return false, errors.Errorf("Failed to get etcd entry. key: %s: Key not found", key)
+	}
+
+	return true, nil
+}
+
+func (c *EtcdV3) List(key string, recursive bool) ([]string, error) {
+	key = normalizeKey(key)
+
+	keys, err := c.kv.keys(dirKey(key))
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to list up etcd keys. key: %s, recursive: %v", key, recursive)
+	}
+
+	if len(keys) == 0 {
+		if !c.HasKey(key) {
+			return nil, errors.Errorf("Failed to list up etcd keys. key: %s, recursive: %v: Key not found", key, recursive)
+		}
+
+		return []string{}, nil
+	}
+
+	return immediateChildren(key, keys), nil
+}
+
+func (c *EtcdV3) Mkdir(key string) error {
+	key = normalizeKey(key)
+
+	isFile, err := c.isFile(key)
+
+	if err != nil {
+		return errors.Wrapf(err, "Failed to create etcd directory. key: %s", key)
+	}
+
+	isDir, err := c.isDir(key)
+
+	if err != nil {
+		return errors.Wrapf(err, "Failed to create etcd directory. key: %s", key)
+	}
+
+	if isFile || isDir {
+		return errors.Wrapf(ErrKeyExists, "Failed to create etcd directory. key: %s", key)
+	}
+
+	if err := c.checkParents(key); err != nil {
+		return errors.Wrapf(err, "Failed to create etcd directory. key: %s", key)
+	}
+
+	if err := c.kv.put(dirKey(key), ""); err != nil {
+		return errors.Wrapf(err, "Failed to create etcd directory. key: %s", key)
+	}
+
+	return nil
+}
+
+func (c *EtcdV3) Set(key, value string) error {
+	key = normalizeKey(key)
+
+	isDir, err := c.isDir(key)
+
+	if err != nil {
+		return errors.Wrapf(err, "Failed to set etcd value. key: %s, value: %s", key, value)
+	}
+
+	if isDir {
+		return errors.Errorf("Failed to set etcd value. key: %s, value: %s: Not a file", key, value)
+	}
+
+	if err := c.checkParents(key); err != nil {
+		return errors.Wrapf(err, "Failed to set etcd value. key: %s, value: %s", key, value)
+	}
+
+	if err := c.kv.put(key, value); err != nil {
+		return errors.Wrapf(err, "Failed to set etcd value. key: %s, value: %s", key, value)
+	}
+
+	return nil
+}
+
+func (c *EtcdV3) TTL(key string) (time.Duration, error) {
+	key = normalizeKey(key)
+
+	if !c.HasKey(key) {
+		return 0, errors.Errorf("Failed to get etcd TTL. key: %s: Key not found", key)
+	}
+
+	ttl, err := c.kv.ttl(key)
+
+	if err != nil {
+		return 0, errors.Wrapf(err, "Failed to get etcd TTL. key: %s", key)
+	}
+
+	return ttl, nil
+}
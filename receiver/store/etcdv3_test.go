@@ -0,0 +1,100 @@
+package store
+
+import (
+	"strings"
+	"time"
+)
+
+// fakeEtcdV3KV imitates etcd v3 flat keyspace in memory. TTL is recorded, but keys never expire.
+type fakeEtcdV3KV struct {
+	values map[string]string
+	ttls   map[string]time.Duration
+}
+
+func newFakeEtcdV3KV() *fakeEtcdV3KV {
+	return &fakeEtcdV3KV{
+		values: map[string]string{},
+		ttls:   map[string]time.Duration{},
+	}
+}
+
+func (kv *fakeEtcdV3KV) matches(key string, prefix bool) []string {
+	keys := []string{}
+
+	for k := range kv.values {
+		if k == key || prefix && strings.HasPrefix(k, key) {
+			keys = append(keys, k)
+		}
+	}
+
+	return keys
+}
+
+func (kv *fakeEtcdV3KV) count(key string, prefix bool) (int64, error) {
+	return int64(len(kv.matches(key, prefix))), nil
+}
+
+func (kv *fakeEtcdV3KV) compareAndDelete(key, prevValue string) (bool, error) {
+	if value, ok := kv.values[key]; !ok || value != prevValue {
+		return false, nil
+	}
+
+	delete(kv.values, key)
+	delete(kv.ttls, key)
+
+	return true, nil
+}
+
+func (kv *fakeEtcdV3KV) compareAndPut(key, prevValue, value string, ttl time.Duration) (bool, error) {
+	if v, ok := kv.values[key]; !ok || v != prevValue {
+		return false, nil
+	}
+
+	kv.values[key] = value
+	kv.ttls[key] = ttl
+
+	return true, nil
+}
+
+func (kv *fakeEtcdV3KV) create(key, value string, ttl time.Duration) (bool, error) {
+	if _, ok := kv.values[key]; ok {
+		return false, nil
+	}
+
+	kv.values[key] = value
+	kv.ttls[key] = ttl
+
+	return true, nil
+}
+
+func (kv *fakeEtcdV3KV) delete(key string, prefix bool) (int64, error) {
+	keys := kv.matches(key, prefix)
+
+	for _, k := range keys {
+		delete(kv.values, k)
+		delete(kv.ttls, k)
+	}
+
+	return int64(len(keys)), nil
+}
+
+func (kv *fakeEtcdV3KV) get(key string) (string, bool, error) {
+	value, ok := kv.values[key]
+
+	return value, ok, nil
+}
+
+func (kv *fakeEtcdV3KV) keys(prefix string) ([]string, error) {
+	return kv.matches(prefix, true), nil
+}
+
+func (kv *fakeEtcdV3KV) put(key, value string) error {
+	kv.values[key] = value
+	delete(kv.ttls, key)
+
+	return nil
+}
+
+func (kv *fakeEtcdV3KV) ttl(key string) (time.Duration, error) {
+	return kv.ttls[key], nil
+}
@@ -0,0 +1,322 @@
+package store
+
+import (
+	"path"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type memoryNode struct {
+	dir       bool
+	expiresAt time.Time
+	value     string
+}
+
+// Memory is an in-memory Store which emulates etcd v2 directory semantics.
+type Memory struct {
+	mu    sync.Mutex
+	nodes map[string]*memoryNode
+	now   func() time.Time
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		nodes: map[string]*memoryNode{
+			rootKey: &memoryNode{dir: true},
+		},
+		now: time.Now,
+	}
+}
+
+// expiresAt returns expiration time of the key set with ttl, or zero time if ttl is 0
+func (m *Memory) expiresAt(ttl time.Duration) time.Time {
+	if ttl == 0 {
+		return time.Time{}
+	}
+
+	return m.now().Add(ttl)
+}
+
+// purgeExpired removes keys whose TTL has passed, as etcd does
+func (m *Memory) purgeExpired() {
+	now := m.now()
+
+	for key, node := range m.nodes {
+		if !node.expiresAt.IsZero() && !now.Before(node.expiresAt) {
+			delete(m.nodes, key)
+		}
+	}
+}
+
+func (m *Memory) children(key string) []string {
+	keys := []string{}
+
+	for k := range m.nodes {
+		keys = append(keys, k)
+	}
+
+	return immediateChildren(key, keys)
+}
+
+func (m *Memory) deleteTree(key string) {
+	for _, child := range m.children(key) {
+		m.deleteTree(child)
+	}
+
+	delete(m.nodes, key)
+}
+
+// mkdirAll creates all parent directories of key, like etcd v2 does on Set.
+func (m *Memory) mkdirAll(key string) error {
+	if key == rootKey {
+		return nil
+	}
+
+	parent := path.Dir(key)
+
+	if err := m.mkdirAll(parent); err != nil {
+		return err
+	}
+
+	node, ok := m.nodes[parent]
+
+	if !ok {
+		m.nodes[parent] = &memoryNode{dir: true}
+	} else if !node.dir {
+		return errors.Errorf("Not a directory. key: %s", parent)
+	}
+
+	return nil
+}
+
+func (m *Memory) CompareAndDelete(key, prevValue string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.purgeExpired()
+
+	key = normalizeKey(key)
+	node, ok := m.nodes[key]
+
+	if !ok || node.dir || node.value != prevValue {
+		return errors.Wrapf(ErrCompareFailed, "Failed to delete memory entry. key: %s", key)
+	}
+
+	delete(m.nodes, key)
+
+	return nil
+}
+
+func (m *Memory) CompareAndSwap(key, prevValue, value string, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.purgeExpired()
+
+	key = normalizeKey(key)
+	node, ok := m.nodes[key]
+
+	if !ok || node.dir || node.value != prevValue {
+		return errors.Wrapf(ErrCompareFailed, "Failed to swap memory value. key: %s", key)
+	}
+
+	m.nodes[key] = &memoryNode{value: value, expiresAt: m.expiresAt(ttl)}
+
+	return nil
+}
+
+func (m *Memory) CreateWithTTL(key, value string, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.purgeExpired()
+
+	key = normalizeKey(key)
+
+	if _, ok := m.nodes[key]; ok {
+		return errors.Wrapf(ErrKeyExists, "Failed to create memory entry. key: %s", key)
+	}
+
+	if err := m.mkdirAll(key); err != nil {
+		return errors.Wrapf(err, "Failed to create memory entry. key: %s", key)
+	}
+
+	m.nodes[key] = &memoryNode{value: value, expiresAt: m.expiresAt(ttl)}
+
+	return nil
+}
+
+func (m *Memory) Delete(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.purgeExpired()
+
+	key = normalizeKey(key)
+	node, ok := m.nodes[key]
+
+	if !ok {
+		return errors.Errorf("Failed to delete memory entry. key: %s: Key not found", key)
+	}
+
+	if node.dir {
+		return errors.Errorf("Failed to delete memory entry. key: %s: Not a file", key)
+	}
+
+	delete(m.nodes, key)
+
+	return nil
+}
+
+func (m *Memory) DeleteDir(key string, recursive bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.purgeExpired()
+
+	key = normalizeKey(key)
+	node, ok := m.nodes[key]
+
+	if !ok {
+		return errors.Errorf("Failed to delete memory directory. key: %s, recursive: %t: Key not found", key, recursive)
+	}
+
+	if key == rootKey {
+		return errors.Errorf("Failed to delete memory directory. key: %s, recursive: %t: Root is read only", key, recursive)
+	}
+
+	if node.dir && !recursive && len(m.children(key)) > 0 {
+		return errors.Errorf("Failed to delete memory directory. key: %s, recursive: %t: Directory not empty", key, recursive)
+	}
+
+	m.deleteTree(key)
+
+	return nil
+}
+
+func (m *Memory) Get(key string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.purgeExpired()
+
+	key = normalizeKey(key)
+	node, ok := m.nodes[key]
+
+	if !ok {
+		return "", errors.Errorf("Failed to get memory value. key: %s: Key not found", key)
+	}
+
+	return node.value, nil
+}
+
+func (m *Memory) HasKey(key string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.purgeExpired()
+
+	_, ok := m.nodes[normalizeKey(key)]
+
+	return ok
+}
+
+func (m *Memory) IsDir(key string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.purgeExpired()
+
+	key = normalizeKey(key)
+	node, ok := m.nodes[key]
+
+	if !ok {
+		return false, errors.Errorf("Failed to get memory entry. key: %s: Key not found", key)
+	}
+
+	return node.dir, nil
+}
+
+func (m *Memory) List(key string, recursive bool) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.purgeExpired()
+
+	key = normalizeKey(key)
+	node, ok := m.nodes[key]
+
+	if !ok {
+		return nil, errors.Errorf("Failed to list up memory keys. key: %s, recursive: %v: Key not found", key, recursive)
+	}
+
+	if !node.dir {
+		return []string{}, nil
+	}
+
+	return m.children(key), nil
+}
+
+func (m *Memory) Mkdir(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.purgeExpired()
+
+	key = normalizeKey(key)
+
+	if _, ok := m.nodes[key]; ok {
+		return errors.Wrapf(ErrKeyExists, "Failed to create memory directory. key: %s", key)
+	}
+
+	if err := m.mkdirAll(key); err != nil {
+		return errors.Wrapf(err, "Failed to create memory directory. key: %s", key)
+	}
+
+	m.nodes[key] = &memoryNode{dir: true}
+
+	return nil
+}
+
+func (m *Memory) Set(key, value string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.purgeExpired()
+
+	key = normalizeKey(key)
+
+	if node, ok := m.nodes[key]; ok && node.dir {
+		return errors.Errorf("Failed to set memory value. key: %s, value: %s: Not a file", key, value)
+	}
+
+	if err := m.mkdirAll(key); err != nil {
+		return errors.Wrapf(err, "Failed to set memory value. key: %s, value: %s", key, value)
+	}
+
+	m.nodes[key] = &memoryNode{value: value}
+
+	return nil
+}
+
+func (m *Memory) TTL(key string) (time.Duration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.purgeExpired()
+
+	key = normalizeKey(key)
+	node, ok := m.nodes[key]
+
+	if !ok {
+		return 0, errors.Errorf("Failed to get memory TTL. key: %s: Key not found", key)
+	}
+
+	if node.expiresAt.IsZero() {
+		return 0, nil
+	}
+
+	return node.expiresAt.Sub(m.now()), nil
+}
@@ -0,0 +1,231 @@
+package store
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestMemorySetAndGet(t *testing.T) {
+	memory := NewMemory()
+
+	if err := memory.Set("/paus/users/dtan4/apps/app/envs/FOO", "bar"); err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	expected := "bar"
+	actual, err := memory.Get("/paus/users/dtan4/apps/app/envs/FOO")
+
+	if err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	if actual != expected {
+		t.Fatalf("Value does not match. expected: %s, actual: %s", expected, actual)
+	}
+
+	for _, key := range []string{"/paus", "/paus/users/dtan4", "/paus/users/dtan4/apps/app/envs/"} {
+		if !memory.HasKey(key) {
+			t.Fatalf("Parent directory %s should be created implicitly.", key)
+		}
+	}
+
+	if _, err := memory.Get("/paus/users/dtan4/apps/app/envs/BAR"); err == nil {
+		t.Fatalf("Error should be raised when getting nonexistent key.")
+	}
+
+	if err := memory.Set("/paus/users/dtan4", "value"); err == nil {
+		t.Fatalf("Error should be raised when setting value to directory.")
+	}
+
+	if err := memory.Set("/paus/users/dtan4/apps/app/envs/FOO/BAR", "value"); err == nil {
+		t.Fatalf("Error should be raised when file is used as directory.")
+	}
+}
+
+func TestMemoryList(t *testing.T) {
+	memory := NewMemory()
+
+	memory.Set("/paus/users/dtan4/apps/app/deployments/1467181319", "19fb23cd71a4cf2eab00ad1a393e40de4ed61531")
+	memory.Set("/paus/users/dtan4/apps/app/deployments/1467181320", "3e634e41d5a819a7586c621a6322ee4d5085232c")
+	memory.Mkdir("/paus/users/dtan4/apps/app/deployments/dir")
+	memory.Set("/paus/users/dtan4/apps/app/deployments/dir/nested", "value")
+
+	expected := []string{
+		"/paus/users/dtan4/apps/app/deployments/1467181319",
+		"/paus/users/dtan4/apps/app/deployments/1467181320",
+		"/paus/users/dtan4/apps/app/deployments/dir",
+	}
+	actual, err := memory.List("/paus/users/dtan4/apps/app/deployments/", false)
+
+	if err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("Keys do not match. expected: %v, actual: %v", expected, actual)
+	}
+
+	if _, err := memory.List("/paus/users/foo", false); err == nil {
+		t.Fatalf("Error should be raised when listing nonexistent directory.")
+	}
+}
+
+func TestMemoryDelete(t *testing.T) {
+	memory := NewMemory()
+
+	memory.Set("/vulcand/backends/app/backend", "{\"Type\": \"http\"}")
+	memory.Set("/vulcand/backends/app/servers/abcdef", "{\"URL\": \"http://127.0.0.1:8080\"}")
+
+	if err := memory.Delete("/vulcand/backends/app/servers"); err == nil {
+		t.Fatalf("Error should be raised when deleting directory as file.")
+	}
+
+	if err := memory.DeleteDir("/vulcand/backends/app/servers", false); err == nil {
+		t.Fatalf("Error should be raised when deleting non-empty directory without recursive option.")
+	}
+
+	if err := memory.DeleteDir("/vulcand/backends/app/servers", true); err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	if memory.HasKey("/vulcand/backends/app/servers/abcdef") {
+		t.Fatalf("Children should be deleted recursively.")
+	}
+
+	if err := memory.Delete("/vulcand/backends/app/backend"); err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	if memory.HasKey("/vulcand/backends/app/backend") {
+		t.Fatalf("Key should be deleted.")
+	}
+
+	if !memory.HasKey("/vulcand/backends/app") {
+		t.Fatalf("Parent directory should not be deleted.")
+	}
+
+	if err := memory.Delete("/vulcand/backends/app/backend"); err == nil {
+		t.Fatalf("Error should be raised when deleting nonexistent key.")
+	}
+}
+
+func TestMemoryMkdir(t *testing.T) {
+	memory := NewMemory()
+
+	if err := memory.Mkdir("/paus/users/dtan4"); err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	if err := memory.Mkdir("/paus/users/dtan4"); errors.Cause(err) != ErrKeyExists {
+		t.Fatalf("ErrKeyExists should be raised when creating existing directory. error: %v", err)
+	}
+
+	memory.Set("/paus/users/dtan4/apps/app/envs/FOO", "bar")
+
+	if err := memory.Mkdir("/paus/users/dtan4/apps/app/envs/FOO"); errors.Cause(err) != ErrKeyExists {
+		t.Fatalf("ErrKeyExists should be raised when creating directory on existing file. error: %v", err)
+	}
+
+	value, err := memory.Get("/paus/users/dtan4/apps/app/envs/FOO")
+
+	if err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	if value != "bar" {
+		t.Fatalf("Existing file should not be replaced. expected: bar, actual: %s", value)
+	}
+
+	if err := memory.Mkdir("/paus/users/dtan5"); err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	keys, err := memory.List("/paus/users/dtan5", false)
+
+	if err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	if len(keys) != 0 {
+		t.Fatalf("Created directory should be empty. actual: %v", keys)
+	}
+}
+
+func TestMemoryCreateWithTTL(t *testing.T) {
+	memory := NewMemory()
+	now := time.Unix(1467181319, 0)
+	memory.now = func() time.Time { return now }
+
+	if err := memory.CreateWithTTL("/paus/users/dtan4/apps/app/lock", "holder1", 10*time.Second); err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	err := memory.CreateWithTTL("/paus/users/dtan4/apps/app/lock", "holder2", 10*time.Second)
+
+	if errors.Cause(err) != ErrKeyExists {
+		t.Fatalf("ErrKeyExists should be raised when the key exists. error: %v", err)
+	}
+
+	if !memory.HasKey("/paus/users/dtan4/apps/app") {
+		t.Fatalf("Parent directory should be created.")
+	}
+
+	now = now.Add(10 * time.Second)
+
+	if memory.HasKey("/paus/users/dtan4/apps/app/lock") {
+		t.Fatalf("Key should expire after TTL.")
+	}
+
+	if err := memory.CreateWithTTL("/paus/users/dtan4/apps/app/lock", "holder2", 0); err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	now = now.Add(24 * time.Hour)
+
+	if !memory.HasKey("/paus/users/dtan4/apps/app/lock") {
+		t.Fatalf("Key without TTL should not expire.")
+	}
+}
+
+func TestMemoryCompareAndSwap(t *testing.T) {
+	memory := NewMemory()
+	now := time.Unix(1467181319, 0)
+	memory.now = func() time.Time { return now }
+
+	memory.CreateWithTTL("/lock", "holder1", 10*time.Second)
+
+	if err := memory.CompareAndSwap("/lock", "holder2", "holder2", 10*time.Second); errors.Cause(err) != ErrCompareFailed {
+		t.Fatalf("ErrCompareFailed should be raised with unexpected value. error: %v", err)
+	}
+
+	now = now.Add(5 * time.Second)
+
+	if err := memory.CompareAndSwap("/lock", "holder1", "holder1", 10*time.Second); err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	now = now.Add(9 * time.Second)
+
+	if !memory.HasKey("/lock") {
+		t.Fatalf("TTL should be refreshed.")
+	}
+
+	if err := memory.CompareAndDelete("/lock", "holder2"); errors.Cause(err) != ErrCompareFailed {
+		t.Fatalf("ErrCompareFailed should be raised with unexpected value. error: %v", err)
+	}
+
+	if err := memory.CompareAndDelete("/lock", "holder1"); err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	if memory.HasKey("/lock") {
+		t.Fatalf("Key should be deleted.")
+	}
+
+	if err := memory.CompareAndSwap("/lock", "holder1", "holder1", 0); errors.Cause(err) != ErrCompareFailed {
+		t.Fatalf("ErrCompareFailed should be raised when the key does not exist. error: %v", err)
+	}
+}
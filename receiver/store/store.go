@@ -0,0 +1,95 @@
+package store
+
+import (
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	rootKey = "/"
+)
+
+var (
+	// ErrCompareFailed is the cause of error raised when the key does not have the expected value
+	ErrCompareFailed = errors.New("Compare failed")
+
+	// ErrKeyExists is the cause of error raised when the key to create already exists
+	ErrKeyExists = errors.New("Key already exists")
+)
+
+// Store is a key-value store which has etcd v2 style directory structure.
+type Store interface {
+	// CompareAndDelete deletes the key only if it has prevValue
+	CompareAndDelete(key, prevValue string) error
+	// CompareAndSwap sets value only if the key has prevValue. The key expires after ttl, or never if ttl is 0.
+	CompareAndSwap(key, prevValue, value string, ttl time.Duration) error
+	// CreateWithTTL sets value only if the key does not exist. The key expires after ttl, or never if ttl is 0.
+	CreateWithTTL(key, value string, ttl time.Duration) error
+	Delete(key string) error
+	DeleteDir(key string, recursive bool) error
+	Get(key string) (string, error)
+	HasKey(key string) bool
+	// IsDir returns whether the key is a directory
+	IsDir(key string) (bool, error)
+	List(key string, recursive bool) ([]string, error)
+	Mkdir(key string) error
+	Set(key, value string) error
+	// TTL returns the remaining time until the key expires, or 0 if the key never expires
+	TTL(key string) (time.Duration, error)
+}
+
+func NewEtcdStore(etcdEndpoint string, apiVersion int64) (Store, error) {
+	switch apiVersion {
+	case 2:
+		etcd, err := NewEtcd(etcdEndpoint)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return etcd, nil
+	case 3:
+		etcd, err := NewEtcdV3(etcdEndpoint)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return etcd, nil
+	}
+
+	return nil, errors.Errorf("Unsupported etcd API version. version: %d", apiVersion)
+}
+
+// immediateChildren picks up keys located just under the directory key, from keys which have the directory key as prefix.
+func immediateChildren(key string, keys []string) []string {
+	result := []string{}
+	found := map[string]bool{}
+
+	prefix := strings.TrimSuffix(key, "/") + "/"
+
+	for _, k := range keys {
+		if !strings.HasPrefix(k, prefix) || k == prefix {
+			continue
+		}
+
+		child := prefix + strings.SplitN(strings.TrimPrefix(k, prefix), "/", 2)[0]
+
+		if !found[child] {
+			found[child] = true
+			result = append(result, child)
+		}
+	}
+
+	sort.Strings(result)
+
+	return result
+}
+
+func normalizeKey(key string) string {
+	return path.Clean(rootKey + key)
+}
@@ -0,0 +1,353 @@
+package store
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestImmediateChildren(t *testing.T) {
+	keys := []string{
+		"/paus/users/dtan4/",
+		"/paus/users/dtan4/apps/app/",
+		"/paus/users/dtan4/apps/app/envs/FOO",
+		"/paus/users/dtan4/apps/app/healthcheck/path",
+		"/paus/users/dtan4/apps/other/",
+		"/paus/users/dtan4foo/",
+	}
+
+	expected := []string{
+		"/paus/users/dtan4/apps/app",
+		"/paus/users/dtan4/apps/other",
+	}
+	actual := immediateChildren("/paus/users/dtan4/apps", keys)
+
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("Children do not match. expected: %v, actual: %v", expected, actual)
+	}
+
+	expected = []string{
+		"/paus/users/dtan4/apps",
+	}
+	actual = immediateChildren("/paus/users/dtan4/", keys)
+
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("Children do not match. expected: %v, actual: %v", expected, actual)
+	}
+}
+
+func storeImplementations() map[string]func() Store {
+	return map[string]func() Store{
+		"memory":  func() Store { return NewMemory() },
+		"etcd v2": func() Store { return &Etcd{newFakeKeysAPI()} },
+		"etcd v3": func() Store { return &EtcdV3{newFakeEtcdV3KV()} },
+	}
+}
+
+func TestStoreSetAndGet(t *testing.T) {
+	for name, newStore := range storeImplementations() {
+		s := newStore()
+
+		if err := s.Set("/paus/users/dtan4/apps/app/envs/FOO", "bar"); err != nil {
+			t.Fatalf("%s: Unexpected error has been raised. error: %s", name, err)
+		}
+
+		value, err := s.Get("/paus/users/dtan4/apps/app/envs/FOO")
+
+		if err != nil {
+			t.Fatalf("%s: Unexpected error has been raised. error: %s", name, err)
+		}
+
+		if value != "bar" {
+			t.Fatalf("%s: Value does not match. expected: bar, actual: %s", name, value)
+		}
+
+		if !s.HasKey("/paus/users/dtan4/apps/app/envs") {
+			t.Fatalf("%s: Parent directory should exist.", name)
+		}
+
+		if _, err := s.Get("/paus/users/dtan4/apps/app/envs/BAZ"); err == nil {
+			t.Fatalf("%s: Error should be raised when getting nonexistent key.", name)
+		}
+
+		if err := s.Set("/paus/users/dtan4/apps/app/envs", "bar"); err == nil {
+			t.Fatalf("%s: Error should be raised when setting value to directory.", name)
+		}
+
+		if err := s.Set("/paus/users/dtan4/apps/app/envs/FOO/BAR", "baz"); err == nil {
+			t.Fatalf("%s: Error should be raised when setting value under file.", name)
+		}
+
+		if s.HasKey("/paus/users/dtan4/apps/app/envs/FOO/BAR") {
+			t.Fatalf("%s: Key under file should not be created.", name)
+		}
+	}
+}
+
+func TestStoreList(t *testing.T) {
+	for name, newStore := range storeImplementations() {
+		s := newStore()
+		s.Set("/paus/users/dtan4/apps/app/envs/FOO", "bar")
+		s.Set("/paus/users/dtan4/apps/app/envs/BAR", "baz")
+		s.Set("/paus/users/dtan4/apps/app/envs/DIR/QUX", "quux")
+
+		expected := []string{
+			"/paus/users/dtan4/apps/app/envs/BAR",
+			"/paus/users/dtan4/apps/app/envs/DIR",
+			"/paus/users/dtan4/apps/app/envs/FOO",
+		}
+		actual, err := s.List("/paus/users/dtan4/apps/app/envs", false)
+
+		if err != nil {
+			t.Fatalf("%s: Unexpected error has been raised. error: %s", name, err)
+		}
+
+		if !reflect.DeepEqual(actual, expected) {
+			t.Fatalf("%s: Keys do not match. expected: %v, actual: %v", name, expected, actual)
+		}
+
+		actual, err = s.List("/paus/users/dtan4/apps/app/envs/FOO", false)
+
+		if err != nil {
+			t.Fatalf("%s: Unexpected error has been raised. error: %s", name, err)
+		}
+
+		if len(actual) != 0 {
+			t.Fatalf("%s: File should have no children. actual: %v", name, actual)
+		}
+
+		if _, err := s.List("/paus/users/dtan4/apps/other", false); err == nil {
+			t.Fatalf("%s: Error should be raised when listing nonexistent key.", name)
+		}
+	}
+}
+
+func TestStoreMkdir(t *testing.T) {
+	for name, newStore := range storeImplementations() {
+		s := newStore()
+
+		if err := s.Mkdir("/paus/users/dtan4/apps/app/envs"); err != nil {
+			t.Fatalf("%s: Unexpected error has been raised. error: %s", name, err)
+		}
+
+		keys, err := s.List("/paus/users/dtan4/apps/app/envs", false)
+
+		if err != nil {
+			t.Fatalf("%s: Unexpected error has been raised. error: %s", name, err)
+		}
+
+		if len(keys) != 0 {
+			t.Fatalf("%s: Created directory should be empty. actual: %v", name, keys)
+		}
+
+		if err := s.Mkdir("/paus/users/dtan4/apps/app/envs"); errors.Cause(err) != ErrKeyExists {
+			t.Fatalf("%s: ErrKeyExists should be raised when creating existing directory. error: %v", name, err)
+		}
+
+		s.Set("/paus/users/dtan4/apps/app/envs/FOO", "bar")
+
+		if err := s.Mkdir("/paus/users/dtan4/apps/app/envs/FOO"); errors.Cause(err) != ErrKeyExists {
+			t.Fatalf("%s: ErrKeyExists should be raised when creating directory on existing file. error: %v", name, err)
+		}
+
+		value, err := s.Get("/paus/users/dtan4/apps/app/envs/FOO")
+
+		if err != nil {
+			t.Fatalf("%s: Unexpected error has been raised. error: %s", name, err)
+		}
+
+		if value != "bar" {
+			t.Fatalf("%s: Existing file should not be replaced. expected: bar, actual: %s", name, value)
+		}
+
+		if err := s.Mkdir("/paus/users/dtan4/apps/app/envs/FOO/BAR"); err == nil {
+			t.Fatalf("%s: Error should be raised when creating directory under file.", name)
+		}
+	}
+}
+
+func TestStoreDelete(t *testing.T) {
+	for name, newStore := range storeImplementations() {
+		s := newStore()
+		s.Set("/paus/users/dtan4/apps/app/envs/FOO", "bar")
+
+		if err := s.Delete("/paus/users/dtan4/apps/app/envs"); err == nil {
+			t.Fatalf("%s: Error should be raised when deleting directory as file.", name)
+		}
+
+		if err := s.Delete("/paus/users/dtan4/apps/app/envs/FOO"); err != nil {
+			t.Fatalf("%s: Unexpected error has been raised. error: %s", name, err)
+		}
+
+		if s.HasKey("/paus/users/dtan4/apps/app/envs/FOO") {
+			t.Fatalf("%s: Key should be deleted.", name)
+		}
+
+		if err := s.Delete("/paus/users/dtan4/apps/app/envs/FOO"); err == nil {
+			t.Fatalf("%s: Error should be raised when deleting nonexistent key.", name)
+		}
+	}
+}
+
+func TestStoreDeleteDir(t *testing.T) {
+	for name, newStore := range storeImplementations() {
+		s := newStore()
+		s.Set("/paus/users/dtan4/apps/app/envs/FOO", "bar")
+
+		if err := s.DeleteDir("/paus/users/dtan4/apps/app", false); err == nil {
+			t.Fatalf("%s: Error should be raised when deleting nonempty directory without recursive.", name)
+		}
+
+		if err := s.DeleteDir("/paus/users/dtan4/apps/app", true); err != nil {
+			t.Fatalf("%s: Unexpected error has been raised. error: %s", name, err)
+		}
+
+		if s.HasKey("/paus/users/dtan4/apps/app") || s.HasKey("/paus/users/dtan4/apps/app/envs/FOO") {
+			t.Fatalf("%s: Directory should be deleted recursively.", name)
+		}
+
+		if err := s.DeleteDir("/paus/users/dtan4/apps/app", true); err == nil {
+			t.Fatalf("%s: Error should be raised when deleting nonexistent directory.", name)
+		}
+	}
+}
+
+func TestStoreCompareAndSwap(t *testing.T) {
+	for name, newStore := range storeImplementations() {
+		s := newStore()
+		s.Set("/paus/users/dtan4/apps/app/lock", "holder1")
+
+		if err := s.CompareAndSwap("/paus/users/dtan4/apps/app/lock", "holder2", "holder3", 0); errors.Cause(err) != ErrCompareFailed {
+			t.Fatalf("%s: ErrCompareFailed should be raised when value does not match. error: %v", name, err)
+		}
+
+		if err := s.CompareAndSwap("/paus/users/dtan4/apps/app/lock", "holder1", "holder3", 0); err != nil {
+			t.Fatalf("%s: Unexpected error has been raised. error: %s", name, err)
+		}
+
+		value, err := s.Get("/paus/users/dtan4/apps/app/lock")
+
+		if err != nil {
+			t.Fatalf("%s: Unexpected error has been raised. error: %s", name, err)
+		}
+
+		if value != "holder3" {
+			t.Fatalf("%s: Value does not match. expected: holder3, actual: %s", name, value)
+		}
+
+		if err := s.CompareAndSwap("/paus/users/dtan4/apps/other/lock", "holder1", "holder3", 0); errors.Cause(err) != ErrCompareFailed {
+			t.Fatalf("%s: ErrCompareFailed should be raised when the key does not exist. error: %v", name, err)
+		}
+	}
+}
+
+func TestStoreCompareAndDelete(t *testing.T) {
+	for name, newStore := range storeImplementations() {
+		s := newStore()
+		s.Set("/paus/users/dtan4/apps/app/lock", "holder1")
+
+		if err := s.CompareAndDelete("/paus/users/dtan4/apps/app/lock", "holder2"); errors.Cause(err) != ErrCompareFailed {
+			t.Fatalf("%s: ErrCompareFailed should be raised when value does not match. error: %v", name, err)
+		}
+
+		if err := s.CompareAndDelete("/paus/users/dtan4/apps/app/lock", "holder1"); err != nil {
+			t.Fatalf("%s: Unexpected error has been raised. error: %s", name, err)
+		}
+
+		if s.HasKey("/paus/users/dtan4/apps/app/lock") {
+			t.Fatalf("%s: Key should be deleted.", name)
+		}
+
+		if err := s.CompareAndDelete("/paus/users/dtan4/apps/app/lock", "holder1"); errors.Cause(err) != ErrCompareFailed {
+			t.Fatalf("%s: ErrCompareFailed should be raised when the key does not exist. error: %v", name, err)
+		}
+	}
+}
+
+func TestStoreCreateWithTTL(t *testing.T) {
+	for name, newStore := range storeImplementations() {
+		s := newStore()
+
+		if err := s.CreateWithTTL("/paus/users/dtan4/apps/app/lock", "holder1", 0); err != nil {
+			t.Fatalf("%s: Unexpected error has been raised. error: %s", name, err)
+		}
+
+		if err := s.CreateWithTTL("/paus/users/dtan4/apps/app/lock", "holder2", 0); errors.Cause(err) != ErrKeyExists {
+			t.Fatalf("%s: ErrKeyExists should be raised when the key exists. error: %v", name, err)
+		}
+
+		value, err := s.Get("/paus/users/dtan4/apps/app/lock")
+
+		if err != nil {
+			t.Fatalf("%s: Unexpected error has been raised. error: %s", name, err)
+		}
+
+		if value != "holder1" {
+			t.Fatalf("%s: Existing value should not be replaced. expected: holder1, actual: %s", name, value)
+		}
+	}
+}
+
+func TestStoreIsDir(t *testing.T) {
+	for name, newStore := range storeImplementations() {
+		s := newStore()
+
+		s.Mkdir("/paus/users/dtan4/apps/app/envs")
+		s.Set("/paus/users/dtan4/apps/app/web-port", "")
+
+		for key, expected := range map[string]bool{
+			"/paus/users/dtan4/apps/app/envs":     true,
+			"/paus/users/dtan4/apps/app":          true,
+			"/paus/users/dtan4/apps/app/web-port": false,
+		} {
+			actual, err := s.IsDir(key)
+
+			if err != nil {
+				t.Fatalf("%s: Unexpected error has been raised. error: %s", name, err)
+			}
+
+			if actual != expected {
+				t.Fatalf("%s: IsDir of %s does not match. expected: %t, actual: %t", name, key, expected, actual)
+			}
+		}
+
+		if _, err := s.IsDir("/paus/users/dtan4/apps/app/scale"); err == nil {
+			t.Fatalf("%s: Error should be raised for missing key.", name)
+		}
+	}
+}
+
+func TestStoreTTL(t *testing.T) {
+	for name, newStore := range storeImplementations() {
+		s := newStore()
+
+		s.CreateWithTTL("/paus/users/dtan4/apps/app/lock", "holder1", 30*time.Second)
+		s.Set("/paus/users/dtan4/apps/app/scale", "2")
+
+		ttl, err := s.TTL("/paus/users/dtan4/apps/app/lock")
+
+		if err != nil {
+			t.Fatalf("%s: Unexpected error has been raised. error: %s", name, err)
+		}
+
+		if ttl <= 0 || ttl > 30*time.Second {
+			t.Fatalf("%s: TTL should be remaining time up to 30s. actual: %s", name, ttl)
+		}
+
+		ttl, err = s.TTL("/paus/users/dtan4/apps/app/scale")
+
+		if err != nil {
+			t.Fatalf("%s: Unexpected error has been raised. error: %s", name, err)
+		}
+
+		if ttl != 0 {
+			t.Fatalf("%s: TTL of key without TTL should be 0. actual: %s", name, ttl)
+		}
+
+		if _, err := s.TTL("/paus/users/dtan4/apps/app/web-port"); err == nil {
+			t.Fatalf("%s: Error should be raised for missing key.", name)
+		}
+	}
+}
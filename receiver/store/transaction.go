@@ -0,0 +1,319 @@
+package store
+
+import (
+	"path"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type undoFunc func() error
+
+// Transaction is a Store which records how to undo every write operation,
+// so that a sequence of writes can be rolled back when it fails midway.
+type Transaction struct {
+	store Store
+	undos []undoFunc
+}
+
+type snapshotEntry struct {
+	key   string
+	value string
+	ttl   time.Duration
+	leaf  bool
+}
+
+func NewTransaction(store Store) *Transaction {
+	return &Transaction{
+		store: store,
+		undos: []undoFunc{},
+	}
+}
+
+// highestMissingKey returns the top-most key among key and its parents which does not exist yet.
+// Store creates these keys implicitly on write.
+func (t *Transaction) highestMissingKey(key string) string {
+	missing := ""
+
+	for k := normalizeKey(key); k != rootKey; k = path.Dir(k) {
+		if t.store.HasKey(k) {
+			break
+		}
+
+		missing = k
+	}
+
+	return missing
+}
+
+func (t *Transaction) snapshot(key string) ([]snapshotEntry, error) {
+	isDir, err := t.store.IsDir(key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !isDir {
+		value, err := t.store.Get(key)
+
+		if err != nil {
+			return nil, err
+		}
+
+		ttl, err := t.store.TTL(key)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return []snapshotEntry{{key: key, value: value, ttl: ttl, leaf: true}}, nil
+	}
+
+	children, err := t.store.List(key, false)
+
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []snapshotEntry{{key: key}}
+
+	for _, child := range children {
+		e, err := t.snapshot(child)
+
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, e...)
+	}
+
+	return entries, nil
+}
+
+// restore recreates keys in snapshot. Directory comes before its children in snapshot, so that it is created first.
+func (t *Transaction) restore(entries []snapshotEntry) error {
+	for _, entry := range entries {
+		if entry.leaf {
+			if err := t.restoreFile(entry.key, entry.value, entry.ttl); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if t.store.HasKey(entry.key) {
+			continue
+		}
+
+		if err := t.store.Mkdir(entry.key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// restoreFile recreates deleted file with its remaining TTL
+func (t *Transaction) restoreFile(key, value string, ttl time.Duration) error {
+	if ttl == 0 {
+		return t.store.Set(key, value)
+	}
+
+	return t.store.CreateWithTTL(key, value, ttl)
+}
+
+func (t *Transaction) CompareAndDelete(key, prevValue string) error {
+	ttl, err := t.store.TTL(key)
+
+	if err != nil {
+		return err
+	}
+
+	if err := t.store.CompareAndDelete(key, prevValue); err != nil {
+		return err
+	}
+
+	t.undos = append(t.undos, func() error {
+		return t.store.CreateWithTTL(key, prevValue, ttl)
+	})
+
+	return nil
+}
+
+func (t *Transaction) CompareAndSwap(key, prevValue, value string, ttl time.Duration) error {
+	prevTTL, err := t.store.TTL(key)
+
+	if err != nil {
+		return err
+	}
+
+	if err := t.store.CompareAndSwap(key, prevValue, value, ttl); err != nil {
+		return err
+	}
+
+	t.undos = append(t.undos, func() error {
+		return t.store.CompareAndSwap(key, value, prevValue, prevTTL)
+	})
+
+	return nil
+}
+
+func (t *Transaction) CreateWithTTL(key, value string, ttl time.Duration) error {
+	missing := t.highestMissingKey(key)
+
+	if err := t.store.CreateWithTTL(key, value, ttl); err != nil {
+		return err
+	}
+
+	t.undos = append(t.undos, func() error {
+		return t.store.DeleteDir(missing, true)
+	})
+
+	return nil
+}
+
+func (t *Transaction) Delete(key string) error {
+	value, err := t.store.Get(key)
+
+	if err != nil {
+		return err
+	}
+
+	ttl, err := t.store.TTL(key)
+
+	if err != nil {
+		return err
+	}
+
+	if err := t.store.Delete(key); err != nil {
+		return err
+	}
+
+	t.undos = append(t.undos, func() error {
+		return t.restoreFile(key, value, ttl)
+	})
+
+	return nil
+}
+
+func (t *Transaction) DeleteDir(key string, recursive bool) error {
+	entries, err := t.snapshot(key)
+
+	if err != nil {
+		return err
+	}
+
+	if err := t.store.DeleteDir(key, recursive); err != nil {
+		return err
+	}
+
+	t.undos = append(t.undos, func() error {
+		return t.restore(entries)
+	})
+
+	return nil
+}
+
+func (t *Transaction) Get(key string) (string, error) {
+	return t.store.Get(key)
+}
+
+func (t *Transaction) HasKey(key string) bool {
+	return t.store.HasKey(key)
+}
+
+func (t *Transaction) IsDir(key string) (bool, error) {
+	return t.store.IsDir(key)
+}
+
+func (t *Transaction) List(key string, recursive bool) ([]string, error) {
+	return t.store.List(key, recursive)
+}
+
+func (t *Transaction) Mkdir(key string) error {
+	missing := t.highestMissingKey(key)
+
+	if err := t.store.Mkdir(key); err != nil {
+		return err
+	}
+
+	if missing != "" {
+		t.undos = append(t.undos, func() error {
+			return t.store.DeleteDir(missing, true)
+		})
+	}
+
+	return nil
+}
+
+func (t *Transaction) Set(key, value string) error {
+	if !t.store.HasKey(key) {
+		missing := t.highestMissingKey(key)
+
+		if err := t.store.Set(key, value); err != nil {
+			return err
+		}
+
+		t.undos = append(t.undos, func() error {
+			return t.store.DeleteDir(missing, true)
+		})
+
+		return nil
+	}
+
+	oldValue, err := t.store.Get(key)
+
+	if err != nil {
+		return err
+	}
+
+	oldTTL, err := t.store.TTL(key)
+
+	if err != nil {
+		return err
+	}
+
+	if err := t.store.Set(key, value); err != nil {
+		return err
+	}
+
+	t.undos = append(t.undos, func() error {
+		if oldTTL == 0 {
+			return t.store.Set(key, oldValue)
+		}
+
+		return t.store.CompareAndSwap(key, value, oldValue, oldTTL)
+	})
+
+	return nil
+}
+
+func (t *Transaction) TTL(key string) (time.Duration, error) {
+	return t.store.TTL(key)
+}
+
+// Rollback undoes all write operations in reverse order.
+// It tries every undo operation even if some of them fail.
+func (t *Transaction) Rollback() error {
+	messages := []string{}
+
+	for i := len(t.undos) - 1; i >= 0; i-- {
+		if err := t.undos[i](); err != nil {
+			messages = append(messages, err.Error())
+		}
+	}
+
+	t.undos = []undoFunc{}
+
+	if len(messages) > 0 {
+		return errors.Errorf("Failed to roll back store. errors: %s", strings.Join(messages, ", "))
+	}
+
+	return nil
+}
+
+// Commit makes all write operations permanent. After that, they are no longer rolled back.
+func (t *Transaction) Commit() {
+	t.undos = []undoFunc{}
+}
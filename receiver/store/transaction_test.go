@@ -0,0 +1,147 @@
+package store
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTransactionRollback(t *testing.T) {
+	memory := NewMemory()
+
+	memory.Mkdir("/paus/users/dtan4/apps/app/deployments")
+	memory.Set("/paus/users/dtan4/apps/app/deployments/1467181319", "19fb23cd71a4cf2eab00ad1a393e40de4ed61531")
+	memory.Set("/vulcand/frontends/dtan4-app-master/frontend", "old")
+	memory.Set("/vulcand/frontends/dtan4-app-3e634e41/frontend", "revision")
+
+	tx := NewTransaction(memory)
+
+	if err := tx.Set("/paus/users/dtan4/apps/app/deployments/1467181320", "3e634e41d5a819a7586c621a6322ee4d5085232c"); err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	if err := tx.Set("/vulcand/backends/dtan4-app-3e634e41/backend", "{\"Type\": \"http\"}"); err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	if err := tx.Set("/vulcand/frontends/dtan4-app-master/frontend", "new"); err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	if err := tx.Mkdir("/paus/users/dtan4/apps/app/envs"); err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	if err := tx.Delete("/paus/users/dtan4/apps/app/deployments/1467181319"); err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	if err := tx.DeleteDir("/vulcand/frontends/dtan4-app-3e634e41", true); err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	if err := tx.Rollback(); err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	for _, key := range []string{
+		"/paus/users/dtan4/apps/app/deployments/1467181320",
+		"/paus/users/dtan4/apps/app/envs",
+		"/vulcand/backends",
+	} {
+		if memory.HasKey(key) {
+			t.Fatalf("%s should be deleted by rollback.", key)
+		}
+	}
+
+	for key, expected := range map[string]string{
+		"/paus/users/dtan4/apps/app/deployments/1467181319": "19fb23cd71a4cf2eab00ad1a393e40de4ed61531",
+		"/vulcand/frontends/dtan4-app-master/frontend":      "old",
+		"/vulcand/frontends/dtan4-app-3e634e41/frontend":    "revision",
+	} {
+		actual, err := memory.Get(key)
+
+		if err != nil {
+			t.Fatalf("%s should be restored by rollback. error: %s", key, err)
+		}
+
+		if actual != expected {
+			t.Fatalf("Value of %s does not match. expected: %s, actual: %s", key, expected, actual)
+		}
+	}
+}
+
+func TestTransactionRollbackKeepsKeyTypesAndTTL(t *testing.T) {
+	memory := NewMemory()
+
+	memory.Set("/vulcand/frontends/dtan4-app-3e634e41/middlewares/empty", "")
+	memory.Mkdir("/vulcand/frontends/dtan4-app-3e634e41/routes")
+	memory.CreateWithTTL("/paus/users/dtan4/apps/app/lock", "holder1", 30*time.Second)
+	memory.CreateWithTTL("/paus/users/dtan4/apps/app/lock-deleted", "holder2", 30*time.Second)
+
+	tx := NewTransaction(memory)
+
+	if err := tx.DeleteDir("/vulcand/frontends/dtan4-app-3e634e41", true); err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	if err := tx.CompareAndSwap("/paus/users/dtan4/apps/app/lock", "holder1", "holder3", 0); err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	if err := tx.CompareAndDelete("/paus/users/dtan4/apps/app/lock-deleted", "holder2"); err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	if err := tx.Rollback(); err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	for key, expected := range map[string]bool{
+		"/vulcand/frontends/dtan4-app-3e634e41/middlewares/empty": false,
+		"/vulcand/frontends/dtan4-app-3e634e41/routes":            true,
+	} {
+		actual, err := memory.IsDir(key)
+
+		if err != nil {
+			t.Fatalf("%s should be restored by rollback. error: %s", key, err)
+		}
+
+		if actual != expected {
+			t.Fatalf("Type of %s should be restored. expected directory: %t, actual: %t", key, expected, actual)
+		}
+	}
+
+	for _, key := range []string{
+		"/paus/users/dtan4/apps/app/lock",
+		"/paus/users/dtan4/apps/app/lock-deleted",
+	} {
+		ttl, err := memory.TTL(key)
+
+		if err != nil {
+			t.Fatalf("%s should be restored by rollback. error: %s", key, err)
+		}
+
+		if ttl <= 0 || ttl > 30*time.Second {
+			t.Fatalf("TTL of %s should be restored. actual: %s", key, ttl)
+		}
+	}
+}
+
+func TestTransactionCommit(t *testing.T) {
+	memory := NewMemory()
+	tx := NewTransaction(memory)
+
+	if err := tx.Set("/vulcand/backends/dtan4-app-3e634e41/backend", "{\"Type\": \"http\"}"); err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	tx.Commit()
+
+	if err := tx.Rollback(); err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	if !memory.HasKey("/vulcand/backends/dtan4-app-3e634e41/backend") {
+		t.Fatalf("Committed key should not be deleted by rollback.")
+	}
+}
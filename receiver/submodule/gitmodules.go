@@ -0,0 +1,184 @@
+package submodule
+
+import (
+	"bufio"
+	"io"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	sectionRegexp = regexp.MustCompile(`^\[\s*([A-Za-z0-9.-]+)(?:\s+"((?:[^"\\]|\\.)*)")?\s*\]\s*(?:[#;].*)?$`)
+	keyRegexp     = regexp.MustCompile(`^([A-Za-z][A-Za-z0-9-]*)\s*(?:=\s*(.*))?$`)
+)
+
+// Submodule is a submodule defined in .gitmodules
+type Submodule struct {
+	Name string
+	Path string
+	URL  string
+}
+
+// ParseGitmodules parses .gitmodules, and returns submodules in the order of definition.
+// Every submodule must have path inside the repository and absolute URL.
+func ParseGitmodules(r io.Reader) ([]*Submodule, error) {
+	submodules := []*Submodule{}
+
+	var current *Submodule
+
+	scanner := bufio.NewScanner(r)
+
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			m := sectionRegexp.FindStringSubmatch(line)
+
+			if m == nil {
+				return nil, errors.Errorf("Invalid section in .gitmodules. line %d: %s", lineNo, line)
+			}
+
+			current = nil
+
+			if strings.ToLower(m[1]) == "submodule" && m[2] != "" {
+				current = &Submodule{Name: unescapeName(m[2])}
+				submodules = append(submodules, current)
+			}
+
+			continue
+		}
+
+		m := keyRegexp.FindStringSubmatch(line)
+
+		if m == nil {
+			return nil, errors.Errorf("Invalid line in .gitmodules. line %d: %s", lineNo, line)
+		}
+
+		if current == nil {
+			continue
+		}
+
+		value, err := parseValue(m[2])
+
+		if err != nil {
+			return nil, errors.Wrapf(err, "Invalid value in .gitmodules. line %d", lineNo)
+		}
+
+		switch strings.ToLower(m[1]) {
+		case "path":
+			current.Path = value
+		case "url":
+			current.URL = value
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "Failed to read .gitmodules.")
+	}
+
+	for _, s := range submodules {
+		if err := s.validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	return submodules, nil
+}
+
+func (s *Submodule) validate() error {
+	if s.Path == "" {
+		return errors.Errorf("Submodule %s in .gitmodules has no path.", s.Name)
+	}
+
+	cleaned := path.Clean(s.Path)
+
+	if path.IsAbs(cleaned) || cleaned == "." || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return errors.Errorf("Submodule %s in .gitmodules has path outside repository. path: %s", s.Name, s.Path)
+	}
+
+	s.Path = cleaned
+
+	if s.URL == "" {
+		return errors.Errorf("Submodule %s in .gitmodules has no url.", s.Name)
+	}
+
+	if strings.HasPrefix(s.URL, "./") || strings.HasPrefix(s.URL, "../") {
+		return errors.Errorf("Submodule %s in .gitmodules has relative url, which cannot be resolved because pushed repository has no remote. url: %s", s.Name, s.URL)
+	}
+
+	if strings.HasPrefix(s.URL, "-") {
+		return errors.Errorf("Submodule %s in .gitmodules has invalid url. url: %s", s.Name, s.URL)
+	}
+
+	return nil
+}
+
+func unescapeName(name string) string {
+	return strings.NewReplacer(`\"`, `"`, `\\`, `\`).Replace(name)
+}
+
+// parseValue parses value in git-config syntax, which may be quoted and followed by comment
+func parseValue(raw string) (string, error) {
+	var (
+		buf     []rune
+		quoted  bool
+		escaped bool
+		pending []rune
+	)
+
+	for _, c := range raw {
+		if escaped {
+			switch c {
+			case 'n':
+				buf = append(buf, pending...)
+				buf = append(buf, '\n')
+			case 't':
+				buf = append(buf, pending...)
+				buf = append(buf, '\t')
+			case '"', '\\':
+				buf = append(buf, pending...)
+				buf = append(buf, c)
+			default:
+				return "", errors.Errorf("Unknown escape sequence \\%c.", c)
+			}
+
+			pending = nil
+			escaped = false
+
+			continue
+		}
+
+		switch {
+		case c == '\\':
+			escaped = true
+		case c == '"':
+			buf = append(buf, pending...)
+			pending = nil
+			quoted = !quoted
+		case !quoted && (c == '#' || c == ';'):
+			return string(buf), nil
+		case !quoted && (c == ' ' || c == '\t'):
+			// Whitespace is kept only if it is followed by other characters
+			if len(buf) > 0 {
+				pending = append(pending, c)
+			}
+		default:
+			buf = append(buf, pending...)
+			buf = append(buf, c)
+			pending = nil
+		}
+	}
+
+	if escaped || quoted {
+		return "", errors.New("Unterminated value.")
+	}
+
+	return string(buf), nil
+}
@@ -0,0 +1,58 @@
+package submodule
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseGitmodules(t *testing.T) {
+	content := `# comment
+[core]
+	bare = false
+[submodule "vendor/lib"]
+	path = vendor/lib
+	url = https://github.com/dtan4/lib.git
+[submodule "themes"] ; comment
+	PATH = "themes/default theme"  # comment
+	url = git@github.com:dtan4/theme.git
+	branch = master
+`
+
+	actual, err := ParseGitmodules(strings.NewReader(content))
+
+	if err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	expected := []*Submodule{
+		{Name: "vendor/lib", Path: "vendor/lib", URL: "https://github.com/dtan4/lib.git"},
+		{Name: "themes", Path: "themes/default theme", URL: "git@github.com:dtan4/theme.git"},
+	}
+
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("Submodules do not match. expected: %+v, actual: %+v", expected, actual)
+	}
+}
+
+func TestParseGitmodulesInvalid(t *testing.T) {
+	testcases := []struct {
+		description string
+		content     string
+	}{
+		{"no path", "[submodule \"lib\"]\n\turl = https://github.com/dtan4/lib.git\n"},
+		{"no url", "[submodule \"lib\"]\n\tpath = lib\n"},
+		{"path traversal", "[submodule \"lib\"]\n\tpath = ../lib\n\turl = https://github.com/dtan4/lib.git\n"},
+		{"absolute path", "[submodule \"lib\"]\n\tpath = /lib\n\turl = https://github.com/dtan4/lib.git\n"},
+		{"relative url", "[submodule \"lib\"]\n\tpath = lib\n\turl = ../lib.git\n"},
+		{"option url", "[submodule \"lib\"]\n\tpath = lib\n\turl = --upload-pack=touch\n"},
+		{"unterminated quote", "[submodule \"lib\"]\n\tpath = \"lib\n\turl = https://github.com/dtan4/lib.git\n"},
+		{"invalid section", "[submodule \"lib\"\n"},
+	}
+
+	for _, tc := range testcases {
+		if _, err := ParseGitmodules(strings.NewReader(tc.content)); err == nil {
+			t.Errorf("Error should be raised with %s.", tc.description)
+		}
+	}
+}
@@ -0,0 +1,359 @@
+package submodule
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/dtan4/paus-gitreceive/receiver/util"
+	"github.com/pkg/errors"
+)
+
+const (
+	allowedProtocols = "file:git:http:https:ssh"
+	gitlinkMode      = "160000"
+	maxDepth         = 10
+	passwordEnvName  = "PAUS_SUBMODULE_PASSWORD"
+	usernameEnvName  = "PAUS_SUBMODULE_USERNAME"
+)
+
+// credentialHelper answers credentials passed through environment variables, so that they do not appear in command line
+const credentialHelper = `!f() { test "$1" = get || exit 0; echo "username=$` + usernameEnvName + `"; echo "password=$` + passwordEnvName + `"; }; f`
+
+// Variables which point git at the pushed repository. They are removed when git runs against mirrors.
+var repositoryEnvNames = []string{
+	"GIT_ALTERNATE_OBJECT_DIRECTORIES",
+	"GIT_DIR",
+	"GIT_INDEX_FILE",
+	"GIT_OBJECT_DIRECTORY",
+	"GIT_QUARANTINE_PATH",
+	"GIT_WORK_TREE",
+}
+
+// FetchFunc is called after each submodule is checked out
+type FetchFunc func(path, url, commit string)
+
+// Fetcher checks out submodules at the commits recorded in the superproject.
+// Submodule repositories are fetched into bare mirrors in CacheDir, and fetched again only if the recorded commit is missing.
+// Mirrors are served without checking credentials again, so CacheDir must not be shared with other apps.
+// Credentials are user:password pairs per host, used for HTTP(S) URLs without userinfo.
+type Fetcher struct {
+	CacheDir    string
+	Credentials map[string]string
+	Callback    FetchFunc
+}
+
+type repository struct {
+	gitDir  string
+	env     []string
+	options []string
+}
+
+// NewFetcher returns fetcher. If cacheDir is empty, mirrors are created in a temporary directory and removed after fetch.
+func NewFetcher(cacheDir string, credentials map[string]string, callback FetchFunc) *Fetcher {
+	return &Fetcher{
+		CacheDir:    cacheDir,
+		Credentials: credentials,
+		Callback:    callback,
+	}
+}
+
+// Fetch checks out submodules recorded in revision of gitDir into dir, including nested submodules
+func (f *Fetcher) Fetch(gitDir, revision, dir string) error {
+	cacheDir := f.CacheDir
+
+	if cacheDir == "" {
+		tmp, err := ioutil.TempDir("", "paus-submodules")
+
+		if err != nil {
+			return errors.Wrap(err, "Failed to create temporary directory for submodules.")
+		}
+
+		defer os.RemoveAll(tmp)
+
+		cacheDir = tmp
+	} else if err := os.MkdirAll(cacheDir, 0755); err != nil {
+		return errors.Wrapf(err, "Failed to create directory %s.", cacheDir)
+	}
+
+	superproject := &repository{
+		gitDir: gitDir,
+		env:    filterEnv(os.Environ(), "GIT_DIR", "GIT_WORK_TREE", "GIT_INDEX_FILE"),
+	}
+
+	return f.fetch(superproject, revision, dir, cacheDir, 0)
+}
+
+func (f *Fetcher) fetch(repo *repository, revision, dir, cacheDir string, depth int) error {
+	content, err := repo.show(revision, ".gitmodules")
+
+	if err != nil {
+		return err
+	}
+
+	if content == nil {
+		return nil
+	}
+
+	if depth >= maxDepth {
+		return errors.Errorf("Submodules are nested too deeply. limit: %d", maxDepth)
+	}
+
+	submodules, err := ParseGitmodules(bytes.NewReader(content))
+
+	if err != nil {
+		return err
+	}
+
+	for _, s := range submodules {
+		commit, err := repo.recordedCommit(revision, s.Path)
+
+		if err != nil {
+			return errors.Wrapf(err, "Failed to resolve submodule %s.", s.Name)
+		}
+
+		mirror, err := f.mirror(cacheDir, s.URL, commit)
+
+		if err != nil {
+			return errors.Wrapf(err, "Failed to fetch submodule %s.", s.Name)
+		}
+
+		target, err := checkoutPath(dir, s.Path)
+
+		if err != nil {
+			return errors.Wrapf(err, "Failed to check out submodule %s.", s.Name)
+		}
+
+		if err := mirror.archive(commit, target); err != nil {
+			return errors.Wrapf(err, "Failed to check out submodule %s.", s.Name)
+		}
+
+		if f.Callback != nil {
+			f.Callback(s.Path, s.URL, commit)
+		}
+
+		if err := f.fetch(mirror, commit, target, cacheDir, depth+1); err != nil {
+			return errors.Wrapf(err, "Failed to fetch submodules of %s.", s.Name)
+		}
+	}
+
+	return nil
+}
+
+// mirror returns bare mirror of url which contains commit, fetching it if necessary
+func (f *Fetcher) mirror(cacheDir, rawurl, commit string) (*repository, error) {
+	sum := sha1.Sum([]byte(rawurl))
+	mirror := &repository{
+		gitDir: filepath.Join(cacheDir, hex.EncodeToString(sum[:])+".git"),
+		env:    append(filterEnv(os.Environ(), repositoryEnvNames...), "GIT_ALLOW_PROTOCOL="+allowedProtocols, "GIT_TERMINAL_PROMPT=0"),
+	}
+
+	if _, err := os.Stat(mirror.gitDir); err != nil {
+		if _, err := mirror.run("init", "--bare", "--quiet", mirror.gitDir); err != nil {
+			return nil, err
+		}
+	}
+
+	if mirror.hasCommit(commit) {
+		return mirror, nil
+	}
+
+	options, env, secret, err := f.credentialOptions(rawurl)
+
+	if err != nil {
+		return nil, err
+	}
+
+	fetch := &repository{
+		gitDir:  mirror.gitDir,
+		env:     append(mirror.env, env...),
+		options: options,
+	}
+
+	if out, err := fetch.run("fetch", "--quiet", "--prune", rawurl, "+refs/heads/*:refs/heads/*", "+refs/tags/*:refs/tags/*"); err != nil {
+		redactor := util.NewRedactor(map[string]string{passwordEnvName: secret})
+		return nil, errors.Errorf("Failed to fetch %s. %s", rawurl, redactor.Redact(strings.TrimSpace(string(out))+" "+err.Error()))
+	}
+
+	if !mirror.hasCommit(commit) {
+		return nil, errors.Errorf("Commit %s is not found in %s. It may not be pushed to any branch or tag.", commit, rawurl)
+	}
+
+	return mirror, nil
+}
+
+// credentialOptions returns git options and environment variables which pass credentials of the host of url to git,
+// and the secret to be redacted from output. Credentials are answered only for the host, and never put in url.
+func (f *Fetcher) credentialOptions(rawurl string) ([]string, []string, string, error) {
+	u, err := url.Parse(rawurl)
+
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.User != nil {
+		return []string{}, []string{}, "", nil
+	}
+
+	credential, ok := f.Credentials[u.Host]
+
+	if !ok {
+		return []string{}, []string{}, "", nil
+	}
+
+	userPassword := strings.SplitN(credential, ":", 2)
+
+	if len(userPassword) != 2 {
+		return nil, nil, "", errors.Errorf("Credential of %s must be user:password.", u.Host)
+	}
+
+	options := []string{"-c", "credential." + u.Scheme + "://" + u.Host + ".helper=" + credentialHelper}
+	env := []string{usernameEnvName + "=" + userPassword[0], passwordEnvName + "=" + userPassword[1]}
+
+	return options, env, userPassword[1], nil
+}
+
+// checkoutPath returns empty directory to check out submodule, which must be located inside dir
+func checkoutPath(dir, submodulePath string) (string, error) {
+	root, err := filepath.EvalSymlinks(dir)
+
+	if err != nil {
+		return "", errors.Wrapf(err, "Failed to resolve directory %s.", dir)
+	}
+
+	target := filepath.Join(root, filepath.FromSlash(submodulePath))
+
+	if err := os.RemoveAll(target); err != nil {
+		return "", errors.Wrapf(err, "Failed to remove %s.", target)
+	}
+
+	if err := os.MkdirAll(target, 0755); err != nil {
+		return "", errors.Wrapf(err, "Failed to create directory %s.", target)
+	}
+
+	real, err := filepath.EvalSymlinks(target)
+
+	if err != nil {
+		return "", errors.Wrapf(err, "Failed to resolve directory %s.", target)
+	}
+
+	if rel, err := filepath.Rel(root, real); err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", errors.Errorf("Submodule path is outside repository. path: %s", submodulePath)
+	}
+
+	return real, nil
+}
+
+func filterEnv(env []string, names ...string) []string {
+	filtered := []string{}
+
+	for _, e := range env {
+		keep := true
+
+		for _, name := range names {
+			if strings.HasPrefix(e, name+"=") {
+				keep = false
+				break
+			}
+		}
+
+		if keep {
+			filtered = append(filtered, e)
+		}
+	}
+
+	return filtered
+}
+
+func (r *repository) command(args ...string) *exec.Cmd {
+	cmd := exec.Command("git", append(append([]string{"--git-dir=" + r.gitDir}, r.options...), args...)...)
+	cmd.Env = r.env
+
+	return cmd
+}
+
+func (r *repository) run(args ...string) ([]byte, error) {
+	out, err := r.command(args...).CombinedOutput()
+
+	if err != nil {
+		return out, errors.Wrapf(err, "git %s failed. gitDir: %s, output: %s", args[0], r.gitDir, strings.TrimSpace(string(out)))
+	}
+
+	return out, nil
+}
+
+func (r *repository) hasCommit(commit string) bool {
+	_, err := r.run("cat-file", "-e", commit+"^{commit}")
+
+	return err == nil
+}
+
+// show returns contents of the file in revision, or nil if it does not exist
+func (r *repository) show(revision, path string) ([]byte, error) {
+	if _, err := r.run("cat-file", "-e", revision+":"+path); err != nil {
+		return nil, nil
+	}
+
+	out, err := r.command("cat-file", "blob", revision+":"+path).Output()
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to read %s in %s.", path, revision)
+	}
+
+	return out, nil
+}
+
+// recordedCommit returns the commit of submodule recorded in the tree of revision
+func (r *repository) recordedCommit(revision, path string) (string, error) {
+	out, err := r.command("ls-tree", "-z", revision, "--", path).Output()
+
+	if err != nil {
+		return "", errors.Wrapf(err, "Failed to read tree of %s.", revision)
+	}
+
+	entry := strings.TrimRight(string(out), "\x00")
+
+	if entry == "" {
+		return "", errors.Errorf("Submodule path %s is not recorded in %s. Run `git submodule update --init` and commit it.", path, revision)
+	}
+
+	fields := strings.Fields(strings.SplitN(entry, "\t", 2)[0])
+
+	if len(fields) != 3 || fields[0] != gitlinkMode {
+		return "", errors.Errorf("%s is not a submodule in %s.", path, revision)
+	}
+
+	return fields[2], nil
+}
+
+// archive extracts files of commit into dir
+func (r *repository) archive(commit, dir string) error {
+	cmd := r.command("archive", "--format=tar", commit)
+	stderr := new(bytes.Buffer)
+	cmd.Stderr = stderr
+
+	stdout, err := cmd.StdoutPipe()
+
+	if err != nil {
+		return errors.Wrap(err, "Failed to create stdout of git archive.")
+	}
+
+	if err := cmd.Start(); err != nil {
+		return errors.Wrap(err, "Failed to run git archive.")
+	}
+
+	if err := util.Unpack(dir, stdout, nil, nil); err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+
+		return err
+	}
+
+	if err := cmd.Wait(); err != nil {
+		return errors.Wrapf(err, "git archive failed. commit: %s, output: %s", commit, strings.TrimSpace(stderr.String()))
+	}
+
+	return nil
+}
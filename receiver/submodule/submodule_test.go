@@ -0,0 +1,252 @@
+package submodule
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func git(t *testing.T, dir string, args ...string) string {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(filterEnv(os.Environ(), repositoryEnvNames...),
+		"GIT_AUTHOR_NAME=paus",
+		"GIT_AUTHOR_EMAIL=paus@example.com",
+		"GIT_COMMITTER_NAME=paus",
+		"GIT_COMMITTER_EMAIL=paus@example.com",
+	)
+
+	out, err := cmd.CombinedOutput()
+
+	if err != nil {
+		t.Fatalf("Unexpected error has been raised. command: git %v, error: %s, output: %s", args, err, out)
+	}
+
+	return strings.TrimSpace(string(out))
+}
+
+func commitFile(t *testing.T, dir, name, body string) string {
+	if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(body), 0644); err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	git(t, dir, "add", name)
+	git(t, dir, "commit", "--quiet", "-m", "Update "+name)
+
+	return git(t, dir, "rev-parse", "HEAD")
+}
+
+// newSuperproject creates repository which records submodule at lib/sub pinned to commit
+func newSuperproject(t *testing.T, base, url, commit string) (string, string) {
+	dir := filepath.Join(base, "super")
+
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	git(t, dir, "init", "--quiet")
+
+	gitmodules := "[submodule \"sub\"]\n\tpath = lib/sub\n\turl = " + url + "\n"
+
+	if err := ioutil.WriteFile(filepath.Join(dir, ".gitmodules"), []byte(gitmodules), 0644); err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	git(t, dir, "add", ".gitmodules")
+	git(t, dir, "update-index", "--add", "--cacheinfo", gitlinkMode, commit, "lib/sub")
+	git(t, dir, "commit", "--quiet", "-m", "Add submodule")
+
+	return filepath.Join(dir, ".git"), git(t, dir, "rev-parse", "HEAD")
+}
+
+func newTempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "paus-submodule")
+
+	if err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	return dir
+}
+
+func TestFetch(t *testing.T) {
+	base := newTempDir(t)
+	defer os.RemoveAll(base)
+
+	sub := filepath.Join(base, "sub")
+
+	if err := os.Mkdir(sub, 0755); err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	git(t, sub, "init", "--quiet")
+	pinned := commitFile(t, sub, "VERSION", "1")
+	commitFile(t, sub, "VERSION", "2")
+
+	url := "file://" + sub
+	gitDir, revision := newSuperproject(t, base, url, pinned)
+	cacheDir := filepath.Join(base, "cache")
+
+	var fetched []string
+
+	fetcher := NewFetcher(cacheDir, nil, func(path, url, commit string) {
+		fetched = append(fetched, path+"@"+commit)
+	})
+
+	for _, name := range []string{"repo1", "repo2"} {
+		dir := filepath.Join(base, name)
+
+		if err := os.MkdirAll(filepath.Join(dir, "lib", "sub"), 0755); err != nil {
+			t.Fatalf("Unexpected error has been raised. error: %s", err)
+		}
+
+		if err := fetcher.Fetch(gitDir, revision, dir); err != nil {
+			t.Fatalf("Unexpected error has been raised. error: %s", err)
+		}
+
+		body, err := ioutil.ReadFile(filepath.Join(dir, "lib", "sub", "VERSION"))
+
+		if err != nil {
+			t.Fatalf("Submodule is not checked out. error: %s", err)
+		}
+
+		if string(body) != "1" {
+			t.Fatalf("Submodule should be checked out at recorded commit. VERSION: %s", body)
+		}
+
+		if _, err := os.Stat(filepath.Join(dir, "lib", "sub", ".git")); !os.IsNotExist(err) {
+			t.Fatalf(".git should not be created in submodule.")
+		}
+
+		// The second fetch must be served from cache
+		os.RemoveAll(sub)
+	}
+
+	if len(fetched) != 2 || fetched[0] != "lib/sub@"+pinned {
+		t.Fatalf("Callback is not called as expected. fetched: %v", fetched)
+	}
+}
+
+func TestFetchWithoutGitmodules(t *testing.T) {
+	base := newTempDir(t)
+	defer os.RemoveAll(base)
+
+	git(t, base, "init", "--quiet")
+	revision := commitFile(t, base, "README", "readme")
+
+	if err := NewFetcher("", nil, nil).Fetch(filepath.Join(base, ".git"), revision, base); err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+}
+
+func TestFetchUnresolvable(t *testing.T) {
+	base := newTempDir(t)
+	defer os.RemoveAll(base)
+
+	sub := filepath.Join(base, "sub")
+
+	if err := os.Mkdir(sub, 0755); err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	git(t, sub, "init", "--quiet")
+	commitFile(t, sub, "VERSION", "1")
+
+	testcases := []struct {
+		description string
+		url         string
+		commit      string
+		message     string
+	}{
+		{"missing commit", "file://" + sub, "0123456789012345678901234567890123456789", "is not found in"},
+		{"missing repository", "file://" + filepath.Join(base, "missing"), "0123456789012345678901234567890123456789", "Failed to fetch"},
+	}
+
+	for _, tc := range testcases {
+		os.RemoveAll(filepath.Join(base, "super"))
+
+		gitDir, revision := newSuperproject(t, base, tc.url, tc.commit)
+		dir := filepath.Join(base, "repo")
+
+		err := NewFetcher("", nil, nil).Fetch(gitDir, revision, dir)
+
+		if err == nil {
+			t.Errorf("Error should be raised with %s.", tc.description)
+			continue
+		}
+
+		if !strings.Contains(err.Error(), tc.message) {
+			t.Errorf("Error message does not explain the cause with %s. error: %s", tc.description, err)
+		}
+	}
+}
+
+func credentialFill(options, env []string, url string) string {
+	cmd := exec.Command("git", append(options, "credential", "fill")...)
+	cmd.Env = append(filterEnv(os.Environ(), repositoryEnvNames...), append(env, "GIT_TERMINAL_PROMPT=0")...)
+	cmd.Stdin = strings.NewReader("url=" + url + "\n\n")
+
+	out, err := cmd.CombinedOutput()
+
+	if err != nil {
+		return ""
+	}
+
+	return string(out)
+}
+
+func TestCredentialOptions(t *testing.T) {
+	fetcher := NewFetcher("", map[string]string{
+		"github.com":  "dtan4:s3cr3t",
+		"example.com": "invalid",
+	}, nil)
+
+	options, env, secret, err := fetcher.credentialOptions("https://github.com/dtan4/lib.git")
+
+	if err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	if secret != "s3cr3t" {
+		t.Fatalf("Secret does not match. expected: s3cr3t, actual: %s", secret)
+	}
+
+	for _, option := range options {
+		if strings.Contains(option, "s3cr3t") {
+			t.Fatalf("Secret should not be passed as command line option. options: %v", options)
+		}
+	}
+
+	out := credentialFill(options, env, "https://github.com/dtan4/lib.git")
+
+	if !strings.Contains(out, "username=dtan4\n") || !strings.Contains(out, "password=s3cr3t\n") {
+		t.Fatalf("Credential should be answered for the host. output: %s", out)
+	}
+
+	if out := credentialFill(options, env, "https://gitlab.com/dtan4/lib.git"); strings.Contains(out, "s3cr3t") {
+		t.Fatalf("Credential should not be answered for other hosts. output: %s", out)
+	}
+
+	for _, url := range []string{
+		"https://user@github.com/dtan4/lib.git",
+		"git@github.com:dtan4/lib.git",
+		"https://gitlab.com/dtan4/lib.git",
+	} {
+		options, env, secret, err := fetcher.credentialOptions(url)
+
+		if err != nil {
+			t.Fatalf("Unexpected error has been raised. error: %s", err)
+		}
+
+		if len(options) != 0 || len(env) != 0 || secret != "" {
+			t.Errorf("Credential should not be used. url: %s", url)
+		}
+	}
+
+	if _, _, _, err := fetcher.credentialOptions("https://example.com/lib.git"); err == nil {
+		t.Fatalf("Error should be raised with invalid credential.")
+	}
+}
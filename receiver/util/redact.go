@@ -0,0 +1,119 @@
+package util
+
+import (
+	"bytes"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	maxRedactBufferSize = 64 * 1024
+	redactedValue       = "[REDACTED]"
+)
+
+// Redactor masks secret values in messages and output
+type Redactor struct {
+	replacer *strings.Replacer
+}
+
+type byLength []string
+
+func (s byLength) Len() int           { return len(s) }
+func (s byLength) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byLength) Less(i, j int) bool { return len(s[i]) > len(s[j]) }
+
+// NewRedactor returns redactor of values of secrets. Empty values are ignored.
+func NewRedactor(secrets map[string]string) *Redactor {
+	values := []string{}
+
+	for _, value := range secrets {
+		if value != "" {
+			values = append(values, value)
+		}
+	}
+
+	// Longer value is replaced first, so that a secret containing another secret is masked entirely
+	sort.Sort(byLength(values))
+
+	oldnew := []string{}
+
+	for _, value := range values {
+		oldnew = append(oldnew, value, redactedValue)
+	}
+
+	return &Redactor{
+		replacer: strings.NewReplacer(oldnew...),
+	}
+}
+
+// Redact returns s with secret values masked
+func (r *Redactor) Redact(s string) string {
+	if r == nil {
+		return s
+	}
+
+	return r.replacer.Replace(s)
+}
+
+// RedactError returns error whose message is masked, or nil if err is nil
+func (r *Redactor) RedactError(err error) error {
+	if err == nil || r == nil {
+		return err
+	}
+
+	return errors.New(r.Redact(err.Error()))
+}
+
+type redactWriter struct {
+	w        io.WriteCloser
+	redactor *Redactor
+	buf      []byte
+}
+
+// Write masks secrets line by line, so that a secret split across writes is masked as well
+func (w *redactWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+
+	i := bytes.LastIndexByte(w.buf, '\n')
+
+	if i < 0 {
+		if len(w.buf) < maxRedactBufferSize {
+			return len(p), nil
+		}
+
+		// Too long line is written without waiting for newline, not to buffer unlimited output
+		i = len(w.buf) - 1
+	}
+
+	if _, err := io.WriteString(w.w, w.redactor.Redact(string(w.buf[0:i+1]))); err != nil {
+		return 0, err
+	}
+
+	w.buf = append([]byte{}, w.buf[i+1:]...)
+
+	return len(p), nil
+}
+
+// Close writes the last incomplete line, and closes the underlying writer
+func (w *redactWriter) Close() error {
+	if len(w.buf) > 0 {
+		if _, err := io.WriteString(w.w, w.redactor.Redact(string(w.buf))); err != nil {
+			return err
+		}
+
+		w.buf = nil
+	}
+
+	return w.w.Close()
+}
+
+// Writer returns writer which masks secrets in output written to w
+func (r *Redactor) Writer(w io.WriteCloser) io.WriteCloser {
+	return &redactWriter{
+		w:        w,
+		redactor: r,
+	}
+}
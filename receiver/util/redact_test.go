@@ -0,0 +1,63 @@
+package util
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+type nopCloser struct {
+	*bytes.Buffer
+}
+
+func (nopCloser) Close() error { return nil }
+
+func TestRedact(t *testing.T) {
+	redactor := NewRedactor(map[string]string{
+		"TOKEN":  "abc",
+		"SECRET": "abcdef",
+		"EMPTY":  "",
+	})
+
+	expected := "token: [REDACTED], secret: [REDACTED]"
+
+	if actual := redactor.Redact("token: abc, secret: abcdef"); actual != expected {
+		t.Fatalf("Redacted string does not match. expected: %s, actual: %s", expected, actual)
+	}
+
+	err := redactor.RedactError(errors.New("Failed to connect with abcdef"))
+
+	if err.Error() != "Failed to connect with [REDACTED]" {
+		t.Fatalf("Redacted error does not match. actual: %s", err)
+	}
+
+	if redactor.RedactError(nil) != nil {
+		t.Fatalf("nil should be returned as is.")
+	}
+
+	if actual := NewRedactor(map[string]string{}).Redact("abc"); actual != "abc" {
+		t.Fatalf("String should not be changed without secrets. actual: %s", actual)
+	}
+}
+
+func TestRedactWriter(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w := NewRedactor(map[string]string{"SECRET": "s3cr3t"}).Writer(nopCloser{buf})
+
+	for _, s := range []string{"SECRET=s3", "cr3t\nPATH=/bin\n", "s3cr3t"} {
+		if _, err := w.Write([]byte(s)); err != nil {
+			t.Fatalf("Unexpected error has been raised. error: %s", err)
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	expected := "SECRET=[REDACTED]\nPATH=/bin\n[REDACTED]"
+
+	if actual := buf.String(); actual != expected {
+		t.Fatalf("Output does not match. expected: %q, actual: %q", expected, actual)
+	}
+}
@@ -0,0 +1,711 @@
+package util
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// unpackProgressInterval is the minimum interval between progress reports
+const unpackProgressInterval = 1 * time.Second
+
+// UnpackProgressFunc is called periodically while unpacking, with the number of files and bytes unpacked so far
+type UnpackProgressFunc func(files, bytes int64)
+
+// UnpackLimits limits files unpacked from tarball. Zero means no limit.
+type UnpackLimits struct {
+	MaxFiles     int64
+	MaxTotalSize int64
+}
+
+type unpacker struct {
+	limits      *UnpackLimits
+	progress    UnpackProgressFunc
+	root        string
+	incremental bool
+
+	// entries holds relative paths of unpacked entries and their parent directories, used in incremental mode
+	entries map[string]bool
+	changed int64
+	removed int64
+
+	files        int64
+	totalSize    int64
+	written      int64
+	lastReported time.Time
+	now          func() time.Time
+}
+
+// progressWriter counts bytes written to the file being unpacked, so that progress of large files is reported while copying
+type progressWriter struct {
+	io.Writer
+	u *unpacker
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	w.u.written += int64(n)
+	w.u.report(false)
+
+	return n, err
+}
+
+// PrintUnpackProgress prints unpack progress with indentation, as NewLineWriter does
+func PrintUnpackProgress(files, bytes int64) {
+	fmt.Printf("       Unpacked %d files, %s\n", files, formatSize(bytes))
+}
+
+func formatSize(bytes int64) string {
+	units := []string{"KiB", "MiB", "GiB", "TiB"}
+
+	if bytes < 1024 {
+		return fmt.Sprintf("%d B", bytes)
+	}
+
+	size := float64(bytes) / 1024
+	unit := 0
+
+	for size >= 1024 && unit < len(units)-1 {
+		size /= 1024
+		unit++
+	}
+
+	return fmt.Sprintf("%.1f %s", size, units[unit])
+}
+
+// UnpackReceivedFiles unpacks tarball from stdin into repository directory.
+// If unpacking fails, files unpacked so far are removed. Files existing before unpacking are left as they are.
+func UnpackReceivedFiles(repositoryDir, username, projectName string, stdin io.Reader, limits *UnpackLimits, progress UnpackProgressFunc) (string, error) {
+	repositoryPath := filepath.Join(repositoryDir, username, projectName)
+	existingPaths, err := existingEntries(repositoryPath)
+
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(repositoryPath, 0777); err != nil {
+		return "", errors.Wrapf(err, "Failed to create directory %s.", repositoryPath)
+	}
+
+	if err := Unpack(repositoryPath, stdin, limits, progress); err != nil {
+		var cleanupErr error
+
+		if existingPaths == nil {
+			cleanupErr = os.RemoveAll(repositoryPath)
+		} else {
+			cleanupErr = RemoveUnpackedFiles(repositoryPath, existingPaths...)
+		}
+
+		if cleanupErr != nil {
+			return "", errors.Wrapf(err, "Failed to clean up %s after unpack failure. cleanup error: %s", repositoryPath, cleanupErr)
+		}
+
+		return "", err
+	}
+
+	return repositoryPath, nil
+}
+
+// UnpackWorkingCopy applies tarball from stdin to the working copy of the application incrementally.
+// Only files whose contents or permission differ are rewritten, and files not contained in tarball are removed.
+// Unchanged files keep their modification time, so that build tools and Docker build cache can reuse them.
+// If unpacking fails, the working copy is removed so that the next push starts from scratch.
+func UnpackWorkingCopy(workingCopyPath string, stdin io.Reader, limits *UnpackLimits, progress UnpackProgressFunc) error {
+	if err := os.MkdirAll(workingCopyPath, 0777); err != nil {
+		return errors.Wrapf(err, "Failed to create directory %s.", workingCopyPath)
+	}
+
+	u, err := newUnpacker(workingCopyPath, limits, progress)
+
+	if err != nil {
+		return err
+	}
+
+	u.incremental = true
+
+	if err := u.unpack(stdin); err != nil {
+		if cleanupErr := os.RemoveAll(workingCopyPath); cleanupErr != nil {
+			return errors.Wrapf(err, "Failed to clean up %s after unpack failure. cleanup error: %s", workingCopyPath, cleanupErr)
+		}
+
+		return err
+	}
+
+	fmt.Printf("       %d files changed, %d files removed\n", u.changed, u.removed)
+
+	return nil
+}
+
+// existingEntries returns paths of entries directly under dir, or nil if dir does not exist
+func existingEntries(dir string) ([]string, error) {
+	fp, err := os.Open(dir)
+
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+
+		return nil, errors.Wrapf(err, "Failed to open %s.", dir)
+	}
+
+	defer fp.Close()
+
+	names, err := fp.Readdirnames(-1)
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to read %s.", dir)
+	}
+
+	paths := []string{}
+
+	for _, name := range names {
+		paths = append(paths, filepath.Join(dir, name))
+	}
+
+	return paths, nil
+}
+
+// Unpack extracts tarball into dir. Files are streamed to disk, so that memory usage does not depend on file size.
+// Entries escaping dir, symlinks pointing outside dir and entries exceeding limits are rejected.
+// progress may be nil.
+func Unpack(dir string, r io.Reader, limits *UnpackLimits, progress UnpackProgressFunc) error {
+	u, err := newUnpacker(dir, limits, progress)
+
+	if err != nil {
+		return err
+	}
+
+	return u.unpack(r)
+}
+
+func newUnpacker(dir string, limits *UnpackLimits, progress UnpackProgressFunc) (*unpacker, error) {
+	root, err := filepath.EvalSymlinks(dir)
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to resolve directory %s.", dir)
+	}
+
+	if limits == nil {
+		limits = &UnpackLimits{}
+	}
+
+	u := &unpacker{
+		limits:   limits,
+		progress: progress,
+		root:     root,
+		entries:  map[string]bool{},
+		now:      time.Now,
+	}
+
+	u.lastReported = u.now()
+
+	return u, nil
+}
+
+func (u *unpacker) unpack(r io.Reader) error {
+	reader := tar.NewReader(r)
+
+	for {
+		header, err := reader.Next()
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return errors.Wrap(err, "Failed to iterate tarball.")
+		}
+
+		if err := u.unpackEntry(header, reader); err != nil {
+			return err
+		}
+
+		u.report(false)
+	}
+
+	if u.incremental {
+		if err := u.removeStaleEntries(); err != nil {
+			return err
+		}
+	}
+
+	if err := u.verifySymlinks(); err != nil {
+		return err
+	}
+
+	u.report(true)
+
+	return nil
+}
+
+// report calls progress function at most once per unpackProgressInterval, or always if force is true
+func (u *unpacker) report(force bool) {
+	if u.progress == nil {
+		return
+	}
+
+	now := u.now()
+
+	if !force && now.Sub(u.lastReported) < unpackProgressInterval {
+		return
+	}
+
+	u.lastReported = now
+	u.progress(u.files, u.written)
+}
+
+// isWithin returns whether path is root itself or located under root. Both must be cleaned.
+func isWithin(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+
+	if err != nil {
+		return false
+	}
+
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// resolve resolves symlinks in the nearest existing ancestor of path (or path itself), and checks that it is located inside root.
+// Missing components are created as real directories later, so that they cannot lead outside root.
+func (u *unpacker) resolve(path string) (string, error) {
+	existing, rest := path, ""
+
+	for {
+		_, err := os.Lstat(existing)
+
+		if err == nil {
+			break
+		}
+
+		if !os.IsNotExist(err) {
+			return "", errors.Wrapf(err, "Failed to stat %s.", existing)
+		}
+
+		rest = filepath.Join(filepath.Base(existing), rest)
+		existing = filepath.Dir(existing)
+	}
+
+	real, err := filepath.EvalSymlinks(existing)
+
+	if err != nil {
+		return "", errors.Wrapf(err, "Failed to resolve %s.", existing)
+	}
+
+	if !isWithin(u.root, real) {
+		return "", errors.Errorf("%s is resolved outside repository directory.", existing)
+	}
+
+	return filepath.Join(real, rest), nil
+}
+
+// entryPath returns the path to write the entry to.
+// Parent directory is resolved so that nothing is written through symlinks pointing outside root.
+func (u *unpacker) entryPath(name string) (string, error) {
+	path := filepath.Join(u.root, name)
+
+	if !isWithin(u.root, path) {
+		return "", errors.Errorf("Tarball entry escapes repository directory. name: %s", name)
+	}
+
+	if path == u.root {
+		return path, nil
+	}
+
+	parent, err := u.resolve(filepath.Dir(path))
+
+	if err != nil {
+		return "", errors.Wrapf(err, "Invalid tarball entry. name: %s", name)
+	}
+
+	return filepath.Join(parent, filepath.Base(path)), nil
+}
+
+func (u *unpacker) countEntry(header *tar.Header) error {
+	u.files++
+
+	if u.limits.MaxFiles > 0 && u.files > u.limits.MaxFiles {
+		return errors.Errorf("Tarball contains too many files. limit: %d", u.limits.MaxFiles)
+	}
+
+	u.totalSize += header.Size
+
+	if u.limits.MaxTotalSize > 0 && u.totalSize > u.limits.MaxTotalSize {
+		return errors.Errorf("Tarball is too large. limit: %d bytes", u.limits.MaxTotalSize)
+	}
+
+	return nil
+}
+
+func (u *unpacker) unpackEntry(header *tar.Header, r io.Reader) error {
+	if header.Typeflag == tar.TypeXGlobalHeader {
+		return nil
+	}
+
+	if err := u.countEntry(header); err != nil {
+		return err
+	}
+
+	outPath, err := u.entryPath(header.Name)
+
+	if err != nil {
+		return err
+	}
+
+	u.recordEntry(outPath)
+
+	switch header.Typeflag {
+	case tar.TypeDir:
+		if err := u.mkdirAll(outPath); err != nil {
+			return errors.Wrapf(err, "Failed to create directory %s from tarball.", outPath)
+		}
+
+		return nil
+	case tar.TypeReg, tar.TypeRegA:
+		return u.writeFile(outPath, header, r)
+	case tar.TypeSymlink:
+		return u.symlink(outPath, header)
+	case tar.TypeLink:
+		return u.link(outPath, header)
+	}
+
+	return errors.Errorf("Unsupported tarball entry type. name: %s, type: %q", header.Name, header.Typeflag)
+}
+
+// recordEntry records path and its parent directories as contained in tarball
+func (u *unpacker) recordEntry(path string) {
+	for p := path; p != u.root && isWithin(u.root, p); p = filepath.Dir(p) {
+		u.entries[p] = true
+	}
+}
+
+// prepare creates parent directories of path, and removes existing entry at path so that it is not followed.
+// In incremental mode, existing directory is removed with its contents, because it may be replaced by file.
+func (u *unpacker) prepare(path string) error {
+	if err := u.mkdirAll(filepath.Dir(path)); err != nil {
+		return errors.Wrapf(err, "Failed to create directory %s.", filepath.Dir(path))
+	}
+
+	remove := os.Remove
+
+	if u.incremental {
+		remove = os.RemoveAll
+	}
+
+	if err := remove(path); err != nil && !os.IsNotExist(err) {
+		return errors.Wrapf(err, "Failed to replace %s.", path)
+	}
+
+	return nil
+}
+
+// mkdirAll creates directory and its parents.
+// In incremental mode, file left in the way by the previous push is removed, because it may be replaced by directory.
+func (u *unpacker) mkdirAll(path string) error {
+	if u.incremental {
+		for p := path; p != u.root && isWithin(u.root, p); p = filepath.Dir(p) {
+			info, err := os.Lstat(p)
+
+			if err != nil {
+				continue
+			}
+
+			if !info.IsDir() {
+				if err := os.Remove(p); err != nil {
+					return err
+				}
+			}
+
+			break
+		}
+	}
+
+	return os.MkdirAll(path, 0755)
+}
+
+// removeStaleEntries removes entries in the working copy which are not contained in tarball
+func (u *unpacker) removeStaleEntries() error {
+	return filepath.Walk(u.root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if path == u.root || u.entries[path] {
+			return nil
+		}
+
+		if err := os.RemoveAll(path); err != nil {
+			return errors.Wrapf(err, "Failed to remove %s.", path)
+		}
+
+		u.removed++
+
+		if info.IsDir() {
+			return filepath.SkipDir
+		}
+
+		return nil
+	})
+}
+
+// compareWriter compares written bytes with the contents of existing file
+type compareWriter struct {
+	r     io.Reader
+	buf   []byte
+	equal bool
+}
+
+func (w *compareWriter) Write(p []byte) (int, error) {
+	if !w.equal {
+		return len(p), nil
+	}
+
+	if cap(w.buf) < len(p) {
+		w.buf = make([]byte, len(p))
+	}
+
+	buf := w.buf[:len(p)]
+
+	if _, err := io.ReadFull(w.r, buf); err != nil || !bytes.Equal(buf, p) {
+		w.equal = false
+	}
+
+	return len(p), nil
+}
+
+// writeFileIncremental writes file to a temporary file next to outPath, and replaces outPath only if contents or permission differ
+func (u *unpacker) writeFileIncremental(outPath string, header *tar.Header, r io.Reader) error {
+	mode := os.FileMode(header.Mode).Perm()
+	info, err := os.Lstat(outPath)
+
+	if err != nil || !info.Mode().IsRegular() || info.Size() != header.Size || info.Mode().Perm() != mode {
+		u.changed++
+		return u.writeNewFile(outPath, header, r)
+	}
+
+	existing, err := os.Open(outPath)
+
+	if err != nil {
+		return errors.Wrapf(err, "Failed to open %s.", outPath)
+	}
+
+	defer existing.Close()
+
+	tmp, err := ioutil.TempFile(filepath.Dir(outPath), "."+filepath.Base(outPath)+".paus-")
+
+	if err != nil {
+		return errors.Wrapf(err, "Failed to create temporary file for %s.", outPath)
+	}
+
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	comparer := &compareWriter{r: existing, equal: true}
+
+	if err := u.copyFile(tmp, io.MultiWriter(tmp, comparer), header, r); err != nil {
+		return err
+	}
+
+	if comparer.equal {
+		return nil
+	}
+
+	u.changed++
+
+	if err := tmp.Chmod(mode); err != nil {
+		return errors.Wrapf(err, "Failed to set permission of %s.", outPath)
+	}
+
+	if err := tmp.Close(); err != nil {
+		return errors.Wrapf(err, "Failed to write file %s from tarball.", outPath)
+	}
+
+	if err := os.Rename(tmp.Name(), outPath); err != nil {
+		return errors.Wrapf(err, "Failed to replace %s.", outPath)
+	}
+
+	return nil
+}
+
+func (u *unpacker) writeFile(outPath string, header *tar.Header, r io.Reader) error {
+	if u.incremental {
+		return u.writeFileIncremental(outPath, header, r)
+	}
+
+	return u.writeNewFile(outPath, header, r)
+}
+
+func (u *unpacker) writeNewFile(outPath string, header *tar.Header, r io.Reader) error {
+	if err := u.prepare(outPath); err != nil {
+		return err
+	}
+
+	mode := os.FileMode(header.Mode).Perm()
+	fp, err := os.OpenFile(outPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+
+	if err != nil {
+		return errors.Wrapf(err, "Failed to create file %s from tarball.", outPath)
+	}
+
+	defer fp.Close()
+
+	if err := u.copyFile(fp, fp, header, r); err != nil {
+		return err
+	}
+
+	// Permission passed to OpenFile is masked by umask, so that executable bit must be set explicitly
+	if err := fp.Chmod(mode); err != nil {
+		return errors.Wrapf(err, "Failed to set permission of %s.", outPath)
+	}
+
+	// Write error such as disk full may be reported at close
+	if err := fp.Close(); err != nil {
+		return errors.Wrapf(err, "Failed to write file %s from tarball.", outPath)
+	}
+
+	return nil
+}
+
+// copyFile streams file contents in tarball to w
+func (u *unpacker) copyFile(fp *os.File, w io.Writer, header *tar.Header, r io.Reader) error {
+	// header.Size is already counted in the total size limit, so copying more than that is an error
+	n, err := io.Copy(&progressWriter{w, u}, io.LimitReader(r, header.Size+1))
+
+	if err != nil {
+		return errors.Wrapf(err, "Failed to copy file contents in %s from tarball.", fp.Name())
+	}
+
+	if n > header.Size {
+		return errors.Errorf("File in tarball is larger than its header. name: %s", header.Name)
+	}
+
+	return nil
+}
+
+// followSymlink follows target from dir component by component as the kernel does, and checks that every step stays inside root.
+// Checking the cleaned path is not enough, because ".." after a symlink component moves from the symlink target.
+func (u *unpacker) followSymlink(dir, target string) error {
+	current := dir
+
+	for _, component := range strings.Split(target, "/") {
+		switch component {
+		case "", ".":
+			continue
+		case "..":
+			current = filepath.Dir(current)
+		default:
+			current = filepath.Join(current, component)
+
+			if info, err := os.Lstat(current); err == nil && info.Mode()&os.ModeSymlink != 0 {
+				real, err := filepath.EvalSymlinks(current)
+
+				if err != nil {
+					return errors.Wrapf(err, "Failed to resolve %s.", current)
+				}
+
+				current = real
+			}
+		}
+
+		if !isWithin(u.root, current) {
+			return errors.Errorf("%s is outside repository directory.", current)
+		}
+	}
+
+	return nil
+}
+
+// verifySymlinks checks that no symlink in the tree points outside root.
+// Symlink checked at creation may be redirected by entries unpacked later, e.g. when its path component is replaced.
+func (u *unpacker) verifySymlinks() error {
+	return filepath.Walk(u.root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.Mode()&os.ModeSymlink == 0 {
+			return nil
+		}
+
+		real, err := filepath.EvalSymlinks(path)
+
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+
+			return errors.Wrapf(err, "Failed to resolve symlink %s.", path)
+		}
+
+		if !isWithin(u.root, real) {
+			return errors.Errorf("Symlink in tarball points outside repository directory. path: %s", path)
+		}
+
+		return nil
+	})
+}
+
+// symlink creates symlink only if its target is located inside root
+func (u *unpacker) symlink(outPath string, header *tar.Header) error {
+	target := header.Linkname
+
+	if filepath.IsAbs(target) {
+		return errors.Errorf("Symlink in tarball has absolute target. name: %s, target: %s", header.Name, target)
+	}
+
+	if err := u.followSymlink(filepath.Dir(outPath), target); err != nil {
+		return errors.Wrapf(err, "Symlink in tarball points outside repository directory. name: %s, target: %s", header.Name, target)
+	}
+
+	if u.incremental {
+		if current, err := os.Readlink(outPath); err == nil && current == target {
+			return nil
+		}
+	}
+
+	if err := u.prepare(outPath); err != nil {
+		return err
+	}
+
+	if err := os.Symlink(target, outPath); err != nil {
+		return errors.Wrapf(err, "Failed to create symlink %s from tarball.", outPath)
+	}
+
+	return nil
+}
+
+// link creates hardlink to a regular file already unpacked
+func (u *unpacker) link(outPath string, header *tar.Header) error {
+	source, err := u.entryPath(header.Linkname)
+
+	if err != nil {
+		return err
+	}
+
+	info, err := os.Lstat(source)
+
+	if err != nil {
+		return errors.Wrapf(err, "Hardlink target in tarball is not found. name: %s, target: %s", header.Name, header.Linkname)
+	}
+
+	if !info.Mode().IsRegular() {
+		return errors.Errorf("Hardlink target in tarball is not a regular file. name: %s, target: %s", header.Name, header.Linkname)
+	}
+
+	if err := u.prepare(outPath); err != nil {
+		return err
+	}
+
+	if err := os.Link(source, outPath); err != nil {
+		return errors.Wrapf(err, "Failed to create hardlink %s from tarball.", outPath)
+	}
+
+	return nil
+}
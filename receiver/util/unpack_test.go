@@ -0,0 +1,421 @@
+package util
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type tarEntry struct {
+	name     string
+	typeflag byte
+	body     string
+	linkname string
+	mode     int64
+}
+
+func buildTarball(t *testing.T, entries []tarEntry) *bytes.Buffer {
+	buf := new(bytes.Buffer)
+	w := tar.NewWriter(buf)
+
+	for _, e := range entries {
+		mode := e.mode
+
+		if mode == 0 {
+			mode = 0644
+		}
+
+		header := &tar.Header{
+			Name:     e.name,
+			Typeflag: e.typeflag,
+			Linkname: e.linkname,
+			Mode:     mode,
+			Size:     int64(len(e.body)),
+		}
+
+		if err := w.WriteHeader(header); err != nil {
+			t.Fatalf("Unexpected error has been raised. error: %s", err)
+		}
+
+		if _, err := w.Write([]byte(e.body)); err != nil {
+			t.Fatalf("Unexpected error has been raised. error: %s", err)
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	return buf
+}
+
+func newTempDir(t *testing.T) (string, string) {
+	base, err := ioutil.TempDir("", "paus-unpack")
+
+	if err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	dir := filepath.Join(base, "repo")
+
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	return base, dir
+}
+
+func TestUnpack(t *testing.T) {
+	base, dir := newTempDir(t)
+	defer os.RemoveAll(base)
+
+	tarball := buildTarball(t, []tarEntry{
+		{name: "bin/", typeflag: tar.TypeDir, mode: 0755},
+		{name: "bin/run", typeflag: tar.TypeReg, body: "#!/bin/sh\n", mode: 0755},
+		{name: "config/app.yml", typeflag: tar.TypeReg, body: "key: value\n"},
+		{name: "app.yml", typeflag: tar.TypeSymlink, linkname: "config/app.yml"},
+		{name: "config/link", typeflag: tar.TypeSymlink, linkname: "../bin"},
+		{name: "run", typeflag: tar.TypeLink, linkname: "bin/run"},
+	})
+
+	if err := Unpack(dir, tarball, &UnpackLimits{MaxFiles: 10, MaxTotalSize: 1024}, nil); err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	info, err := os.Stat(filepath.Join(dir, "bin", "run"))
+
+	if err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	if info.Mode().Perm() != 0755 {
+		t.Fatalf("Executable bit is not preserved. mode: %s", info.Mode())
+	}
+
+	body, err := ioutil.ReadFile(filepath.Join(dir, "app.yml"))
+
+	if err != nil {
+		t.Fatalf("Symlink is not created. error: %s", err)
+	}
+
+	if string(body) != "key: value\n" {
+		t.Fatalf("Symlink target does not match. body: %s", body)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "config", "link", "run")); err != nil {
+		t.Fatalf("Directory symlink is not created. error: %s", err)
+	}
+
+	linkInfo, err := os.Stat(filepath.Join(dir, "run"))
+
+	if err != nil {
+		t.Fatalf("Hardlink is not created. error: %s", err)
+	}
+
+	if !os.SameFile(info, linkInfo) {
+		t.Fatalf("Hardlink does not point at the same file.")
+	}
+}
+
+func TestUnpackRejectsUnsafeEntries(t *testing.T) {
+	testcases := []struct {
+		description string
+		entries     []tarEntry
+	}{
+		{
+			"path traversal",
+			[]tarEntry{
+				{name: "../escaped", typeflag: tar.TypeReg, body: "x"},
+			},
+		},
+		{
+			"nested path traversal",
+			[]tarEntry{
+				{name: "a/../../escaped", typeflag: tar.TypeReg, body: "x"},
+			},
+		},
+		{
+			"absolute symlink",
+			[]tarEntry{
+				{name: "link", typeflag: tar.TypeSymlink, linkname: "/etc/passwd"},
+			},
+		},
+		{
+			"symlink to parent",
+			[]tarEntry{
+				{name: "link", typeflag: tar.TypeSymlink, linkname: "../"},
+			},
+		},
+		{
+			"symlink through symlink",
+			[]tarEntry{
+				{name: "a/b", typeflag: tar.TypeSymlink, linkname: ".."},
+				{name: "link", typeflag: tar.TypeSymlink, linkname: "a/b/.."},
+			},
+		},
+		{
+			"write through dangling symlink",
+			[]tarEntry{
+				{name: "a/b", typeflag: tar.TypeSymlink, linkname: ".."},
+				{name: "link", typeflag: tar.TypeSymlink, linkname: "a/b/../escaped"},
+				{name: "link/file", typeflag: tar.TypeReg, body: "x"},
+			},
+		},
+		{
+			"hardlink outside",
+			[]tarEntry{
+				{name: "link", typeflag: tar.TypeLink, linkname: "../outside"},
+			},
+		},
+		{
+			"fifo",
+			[]tarEntry{
+				{name: "fifo", typeflag: tar.TypeFifo},
+			},
+		},
+	}
+
+	for _, tc := range testcases {
+		base, dir := newTempDir(t)
+
+		ioutil.WriteFile(filepath.Join(base, "outside"), []byte("secret"), 0644)
+
+		if err := Unpack(dir, buildTarball(t, tc.entries), nil, nil); err == nil {
+			t.Errorf("Error should be raised with %s.", tc.description)
+		}
+
+		if _, err := os.Stat(filepath.Join(base, "escaped")); err == nil {
+			t.Errorf("File should not be written outside with %s.", tc.description)
+		}
+
+		os.RemoveAll(base)
+	}
+}
+
+func TestUnpackLimits(t *testing.T) {
+	entries := []tarEntry{
+		{name: "a", typeflag: tar.TypeReg, body: "12345"},
+		{name: "b", typeflag: tar.TypeReg, body: "12345"},
+	}
+
+	testcases := []struct {
+		limits *UnpackLimits
+		ok     bool
+	}{
+		{&UnpackLimits{}, true},
+		{&UnpackLimits{MaxFiles: 2, MaxTotalSize: 10}, true},
+		{&UnpackLimits{MaxFiles: 1}, false},
+		{&UnpackLimits{MaxTotalSize: 9}, false},
+	}
+
+	for _, tc := range testcases {
+		base, dir := newTempDir(t)
+
+		err := Unpack(dir, buildTarball(t, entries), tc.limits, nil)
+
+		if tc.ok && err != nil {
+			t.Errorf("Unexpected error has been raised. limits: %+v, error: %s", tc.limits, err)
+		}
+
+		if !tc.ok && err == nil {
+			t.Errorf("Error should be raised when exceeding limits. limits: %+v", tc.limits)
+		}
+
+		os.RemoveAll(base)
+	}
+}
+
+func TestUnpackProgress(t *testing.T) {
+	base, dir := newTempDir(t)
+	defer os.RemoveAll(base)
+
+	tarball := buildTarball(t, []tarEntry{
+		{name: "a", typeflag: tar.TypeReg, body: "12345"},
+		{name: "b/", typeflag: tar.TypeDir, mode: 0755},
+		{name: "b/c", typeflag: tar.TypeReg, body: "123"},
+	})
+
+	var files, bytes int64
+
+	err := Unpack(dir, tarball, nil, func(f, b int64) {
+		files, bytes = f, b
+	})
+
+	if err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	if files != 3 || bytes != 8 {
+		t.Fatalf("Final progress does not match. files: %d, bytes: %d", files, bytes)
+	}
+}
+
+func TestUnpackReceivedFilesCleanup(t *testing.T) {
+	base, err := ioutil.TempDir("", "paus-unpack")
+
+	if err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	defer os.RemoveAll(base)
+
+	entries := []tarEntry{
+		{name: "a", typeflag: tar.TypeReg, body: "12345"},
+		{name: "b/c", typeflag: tar.TypeReg, body: "12345"},
+		{name: "../escaped", typeflag: tar.TypeReg, body: "x"},
+	}
+
+	if _, err := UnpackReceivedFiles(base, "dtan4", "app-19fb23cd", buildTarball(t, entries), nil, nil); err == nil {
+		t.Fatalf("Error should be raised with invalid tarball.")
+	}
+
+	repositoryPath := filepath.Join(base, "dtan4", "app-19fb23cd")
+
+	if _, err := os.Stat(repositoryPath); !os.IsNotExist(err) {
+		t.Fatalf("Repository directory created by unpacking should be removed. error: %v", err)
+	}
+
+	composeFilePath := filepath.Join(repositoryPath, "docker-compose-1467181319.yml")
+
+	if err := os.MkdirAll(repositoryPath, 0755); err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	if err := ioutil.WriteFile(composeFilePath, []byte("version: '2'\n"), 0644); err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	if _, err := UnpackReceivedFiles(base, "dtan4", "app-19fb23cd", buildTarball(t, entries), nil, nil); err == nil {
+		t.Fatalf("Error should be raised with invalid tarball.")
+	}
+
+	files, err := ioutil.ReadDir(repositoryPath)
+
+	if err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	if len(files) != 1 || files[0].Name() != "docker-compose-1467181319.yml" {
+		t.Fatalf("Only files existing before unpacking should be left. files: %v", files)
+	}
+}
+
+func TestFormatSize(t *testing.T) {
+	testcases := []struct {
+		bytes    int64
+		expected string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1536, "1.5 KiB"},
+		{10 * 1024 * 1024, "10.0 MiB"},
+		{3 * 1024 * 1024 * 1024, "3.0 GiB"},
+	}
+
+	for _, tc := range testcases {
+		if actual := formatSize(tc.bytes); actual != tc.expected {
+			t.Errorf("Size does not match. expected: %s, actual: %s", tc.expected, actual)
+		}
+	}
+}
+
+func TestUnpackWorkingCopy(t *testing.T) {
+	base, dir := newTempDir(t)
+	defer os.RemoveAll(base)
+
+	first := buildTarball(t, []tarEntry{
+		{name: "Gemfile", typeflag: tar.TypeReg, body: "gem 'rails'\n"},
+		{name: "app/main.rb", typeflag: tar.TypeReg, body: "puts 1\n"},
+		{name: "app/old.rb", typeflag: tar.TypeReg, body: "old\n"},
+		{name: "config", typeflag: tar.TypeReg, body: "file\n"},
+		{name: "current", typeflag: tar.TypeSymlink, linkname: "app"},
+		{name: "tmp/cache/data", typeflag: tar.TypeReg, body: "cache\n"},
+	})
+
+	if err := UnpackWorkingCopy(dir, first, nil, nil); err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	old := time.Unix(1467181319, 0)
+
+	if err := os.Chtimes(filepath.Join(dir, "Gemfile"), old, old); err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	second := buildTarball(t, []tarEntry{
+		{name: "Gemfile", typeflag: tar.TypeReg, body: "gem 'rails'\n"},
+		{name: "app/main.rb", typeflag: tar.TypeReg, body: "puts 2\n"},
+		{name: "config/app.yml", typeflag: tar.TypeReg, body: "key: value\n"},
+		{name: "current", typeflag: tar.TypeSymlink, linkname: "app"},
+	})
+
+	if err := UnpackWorkingCopy(dir, second, nil, nil); err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	info, err := os.Stat(filepath.Join(dir, "Gemfile"))
+
+	if err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	if !info.ModTime().Equal(old) {
+		t.Fatalf("Unchanged file should not be rewritten. mtime: %s", info.ModTime())
+	}
+
+	expected := map[string]string{
+		"app/main.rb":     "puts 2\n",
+		"config/app.yml":  "key: value\n",
+		"current/main.rb": "puts 2\n",
+	}
+
+	for name, body := range expected {
+		actual, err := ioutil.ReadFile(filepath.Join(dir, name))
+
+		if err != nil {
+			t.Fatalf("Unexpected error has been raised. error: %s", err)
+		}
+
+		if string(actual) != body {
+			t.Errorf("File contents does not match. name: %s, expected: %q, actual: %q", name, body, actual)
+		}
+	}
+
+	for _, name := range []string{"app/old.rb", "tmp"} {
+		if _, err := os.Lstat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+			t.Errorf("File not contained in tarball should be removed. name: %s", name)
+		}
+	}
+
+	files, err := ioutil.ReadDir(dir)
+
+	if err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	if len(files) != 4 {
+		t.Fatalf("Temporary files should not be left. files: %v", files)
+	}
+}
+
+func TestUnpackWorkingCopyCleanup(t *testing.T) {
+	base, dir := newTempDir(t)
+	defer os.RemoveAll(base)
+
+	tarball := buildTarball(t, []tarEntry{
+		{name: "a", typeflag: tar.TypeReg, body: "12345"},
+		{name: "../escaped", typeflag: tar.TypeReg, body: "x"},
+	})
+
+	if err := UnpackWorkingCopy(dir, tarball, nil, nil); err == nil {
+		t.Fatalf("Error should be raised with invalid tarball.")
+	}
+
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Fatalf("Working copy should be removed after failure. error: %v", err)
+	}
+}
@@ -1,14 +1,11 @@
 package util
 
 import (
-	"archive/tar"
 	"bufio"
-	"bytes"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"sort"
 	"strconv"
@@ -25,66 +22,56 @@ func printLine(r io.Reader) {
 	}
 }
 
-func GetSubmodules(repositoryPath string) error {
-	dir := filepath.Join(repositoryPath, ".git")
+type lineWriter struct {
+	*io.PipeWriter
+	done chan struct{}
+}
 
-	stat, err := os.Stat(dir)
+// Close closes the writer, and waits until all written lines are printed
+func (w *lineWriter) Close() error {
+	err := w.PipeWriter.Close()
+	<-w.done
 
-	if err == nil && stat.IsDir() {
-		if e := os.RemoveAll(dir); e != nil {
-			return errors.Wrapf(e, "Failed to remove %s.", dir)
-		}
-	}
+	return err
+}
 
-	cmd := exec.Command("/usr/local/bin/get-submodules")
+// NewLineWriter returns writer which prints written output line by line with indentation
+func NewLineWriter() io.WriteCloser {
+	r, w := io.Pipe()
+	done := make(chan struct{})
 
-	if err = RunCommand(cmd); err != nil {
-		return err
-	}
+	go func() {
+		printLine(r)
+		close(done)
+	}()
 
-	return nil
+	return &lineWriter{w, done}
 }
 
-func RemoveUnpackedFiles(repositoryPath, newComposeFilePath string) error {
+// RemoveUnpackedFiles removes all files in repositoryPath except keepFilePaths
+func RemoveUnpackedFiles(repositoryPath string, keepFilePaths ...string) error {
 	files, err := ioutil.ReadDir(repositoryPath)
 
 	if err != nil {
 		return errors.Wrapf(err, "Failed to open %s.", repositoryPath)
 	}
 
-	for _, file := range files {
-		if filepath.Join(repositoryPath, file.Name()) != newComposeFilePath {
-			path := filepath.Join(repositoryPath, file.Name())
-
-			if err = os.RemoveAll(path); err != nil {
-				return errors.Wrapf(err, "Failed to remove files in %s.", path)
-			}
-		}
-	}
-
-	return nil
-}
-
-func RunCommand(cmd *exec.Cmd) error {
-	stdout, err := cmd.StdoutPipe()
-
-	if err != nil {
-		return errors.Wrapf(err, "creating stdout failed. command: %v", cmd.Args)
-	}
-
-	stderr, err := cmd.StderrPipe()
+	keep := map[string]bool{}
 
-	if err != nil {
-		return errors.Wrapf(err, "creating stderr failed. command: %v", cmd.Args)
+	for _, path := range keepFilePaths {
+		keep[path] = true
 	}
 
-	cmd.Start()
+	for _, file := range files {
+		path := filepath.Join(repositoryPath, file.Name())
 
-	go printLine(stdout)
-	go printLine(stderr)
+		if keep[path] {
+			continue
+		}
 
-	if err = cmd.Wait(); err != nil {
-		return errors.Wrapf(err, "command execution failed. command: %v", cmd.Args)
+		if err = os.RemoveAll(path); err != nil {
+			return errors.Wrapf(err, "Failed to remove files in %s.", path)
+		}
 	}
 
 	return nil
@@ -105,48 +92,3 @@ func SortKeys(kv map[string]string) []string {
 func Timestamp() string {
 	return strconv.FormatInt(time.Now().Unix(), 10)
 }
-
-func UnpackReceivedFiles(repositoryDir, username, projectName string, stdin io.Reader) (string, error) {
-	repositoryPath := filepath.Join(repositoryDir, username, projectName)
-
-	if err := os.MkdirAll(repositoryPath, 0777); err != nil {
-		return "", errors.Wrapf(err, "Failed to create directory %s.", repositoryPath)
-	}
-
-	reader := tar.NewReader(stdin)
-
-	for {
-		header, err := reader.Next()
-
-		if err == io.EOF {
-			break
-		}
-
-		if err != nil {
-			return "", errors.Wrap(err, "Failed to iterate tarball.")
-		}
-
-		buffer := new(bytes.Buffer)
-		outPath := filepath.Join(repositoryPath, header.Name)
-
-		switch header.Typeflag {
-		case tar.TypeDir:
-			if _, err = os.Stat(outPath); err != nil {
-				if err = os.MkdirAll(outPath, 0755); err != nil {
-					return "", errors.Wrapf(err, "Failed to create directory %s from tarball.", outPath)
-				}
-			}
-
-		case tar.TypeReg, tar.TypeRegA:
-			if _, err = io.Copy(buffer, reader); err != nil {
-				return "", errors.Wrapf(err, "Failed to copy file contents in %s from tarball.", outPath)
-			}
-
-			if err = ioutil.WriteFile(outPath, buffer.Bytes(), os.FileMode(header.Mode)); err != nil {
-				return "", errors.Wrapf(err, "Failed to create file %s from tarball.", outPath)
-			}
-		}
-	}
-
-	return repositoryPath, nil
-}
@@ -15,20 +15,24 @@ type Backend struct {
 }
 
 // {"Type": "http"}
-func setBackend(etcd *store.Etcd, projectName string) error {
+func setBackend(store store.Store, projectName string) error {
 	key := fmt.Sprintf("%s/backends/%s/backend", vulcandKeyBase, projectName)
 
-	if err := etcd.Set(key, httpBackendJSON); err != nil {
+	if err := store.Set(key, httpBackendJSON); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func unsetBackend(etcd *store.Etcd, projectName string) error {
+func unsetBackend(store store.Store, projectName string) error {
 	key := fmt.Sprintf("%s/backends/%s/backend", vulcandKeyBase, projectName)
 
-	if err := etcd.Delete(key); err != nil {
+	if !store.HasKey(key) {
+		return nil
+	}
+
+	if err := store.Delete(key); err != nil {
 		return err
 	}
 
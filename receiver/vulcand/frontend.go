@@ -22,7 +22,7 @@ type FrontendSettings struct {
 }
 
 // {"Type": "http", "BackendId": "$identifier", "Route": "Host(`$identifier.$base_domain`) && PathRegexp(`/`)", "Settings": {"TrustForwardHeader": true}}
-func setFrontend(etcd *store.Etcd, projectName, identifier, baseDomain string) error {
+func setFrontend(store store.Store, projectName, identifier, baseDomain string) error {
 	key := fmt.Sprintf("%s/frontends/%s/frontend", vulcandKeyBase, identifier)
 	frontend := Frontend{
 		Type:      "http",
@@ -43,15 +43,60 @@ func setFrontend(etcd *store.Etcd, projectName, identifier, baseDomain string) e
 	b = bytes.Replace(b, []byte("\\u0026"), []byte("&"), -1)
 	json := string(b)
 
-	if err := etcd.Set(key, json); err != nil {
+	if err := store.Set(key, json); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func unsetFrontend(etcd *store.Etcd, identifier string) error {
-	if err := etcd.DeleteDir(fmt.Sprintf("%s/frontends/%s", vulcandKeyBase, identifier), true); err != nil {
+func getFrontend(store store.Store, identifier string) (*Frontend, error) {
+	key := fmt.Sprintf("%s/frontends/%s/frontend", vulcandKeyBase, identifier)
+
+	value, err := store.Get(key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var frontend Frontend
+
+	if err := json.Unmarshal([]byte(value), &frontend); err != nil {
+		return nil, errors.Wrapf(err, "Failed to parse vulcand frontend JSON. key: %s", key)
+	}
+
+	return &frontend, nil
+}
+
+func listFrontends(store store.Store) ([]string, error) {
+	key := fmt.Sprintf("%s/frontends", vulcandKeyBase)
+	identifiers := []string{}
+
+	if !store.HasKey(key) {
+		return identifiers, nil
+	}
+
+	keys, err := store.List(key, false)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, k := range keys {
+		identifiers = append(identifiers, strings.TrimPrefix(k, key+"/"))
+	}
+
+	return identifiers, nil
+}
+
+func unsetFrontend(store store.Store, identifier string) error {
+	key := fmt.Sprintf("%s/frontends/%s", vulcandKeyBase, identifier)
+
+	if !store.HasKey(key) {
+		return nil
+	}
+
+	if err := store.DeleteDir(key, true); err != nil {
 		return err
 	}
 
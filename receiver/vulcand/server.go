@@ -3,6 +3,7 @@ package vulcand
 import (
 	"encoding/json"
 	"fmt"
+	"path"
 
 	"github.com/dtan4/paus-gitreceive/receiver/model"
 	"github.com/dtan4/paus-gitreceive/receiver/store"
@@ -14,7 +15,7 @@ type Server struct {
 }
 
 // {"URL": "http://$web_container_host_ip:$web_container_port"}
-func setServer(etcd *store.Etcd, projectName string, container *model.Container, baseDomain string) error {
+func setServer(store store.Store, projectName string, container *model.Container, baseDomain string) error {
 	key := fmt.Sprintf("%s/backends/%s/servers/%s", vulcandKeyBase, projectName, container.ContainerId)
 	server := Server{
 		URL: fmt.Sprintf("http://%s:%s", container.HostIP(), container.HostPort()),
@@ -28,19 +29,56 @@ func setServer(etcd *store.Etcd, projectName string, container *model.Container,
 
 	json := string(b)
 
-	if err := etcd.Set(key, json); err != nil {
+	if err := store.Set(key, json); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func unsetServer(etcd *store.Etcd, projectName string) error {
+func unsetServer(store store.Store, projectName string) error {
 	key := fmt.Sprintf("%s/backends/%s/servers", vulcandKeyBase, projectName)
 
-	if err := etcd.DeleteDir(key, true); err != nil {
+	if !store.HasKey(key) {
+		return nil
+	}
+
+	if err := store.DeleteDir(key, true); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// unsetStaleServers removes servers of the backend other than containers, keeping servers being registered in place
+func unsetStaleServers(store store.Store, projectName string, containers []*model.Container) error {
+	key := fmt.Sprintf("%s/backends/%s/servers", vulcandKeyBase, projectName)
+
+	if !store.HasKey(key) {
+		return nil
+	}
+
+	current := map[string]bool{}
+
+	for _, container := range containers {
+		current[container.ContainerId] = true
+	}
+
+	servers, err := store.List(key, false)
+
+	if err != nil {
 		return err
 	}
 
+	for _, server := range servers {
+		if current[path.Base(server)] {
+			continue
+		}
+
+		if err := store.Delete(server); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
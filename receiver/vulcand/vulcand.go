@@ -1,7 +1,9 @@
 package vulcand
 
 import (
+	"fmt"
 	"regexp"
+	"sort"
 	"strings"
 
 	"github.com/dtan4/paus-gitreceive/receiver/model"
@@ -16,59 +18,226 @@ var (
 	branchRegexp = regexp.MustCompile(`[^a-zA-Z0-9.-]`)
 )
 
-func DeregisterInformation(etcd *store.Etcd, deployment *model.Deployment) error {
-	if err := unsetServer(etcd, deployment.ProjectName); err != nil {
-		return err
+// dtan4-app
+func appIdentifier(deployment *model.Deployment) string {
+	return strings.ToLower(deployment.App.Username + "-" + deployment.App.AppName)
+}
+
+// dtan4-app-master
+func branchIdentifier(deployment *model.Deployment) string {
+	return truncateIdentifier(strings.ToLower(deployment.App.Username + "-" + deployment.App.AppName + "-" + branchRegexp.ReplaceAllString(deployment.Branch, "-")))
+}
+
+// dtan4-app-master, (dtan4-app)
+func branchFrontendIdentifiers(deployment *model.Deployment) []string {
+	identifiers := []string{
+		branchIdentifier(deployment),
+	}
+
+	if deployment.Branch == "master" {
+		identifiers = append(identifiers, appIdentifier(deployment))
+	}
+
+	return identifiers
+}
+
+// dtan4-app-master, dtan4-app-19fb23cd, (dtan4-app)
+func frontendIdentifiers(deployment *model.Deployment) []string {
+	identifiers := []string{
+		branchIdentifier(deployment),
+		revisionIdentifier(deployment),
+	}
+
+	if deployment.Branch == "master" {
+		identifiers = append(identifiers, appIdentifier(deployment))
+	}
+
+	return identifiers
+}
+
+// dtan4-app-19fb23cd-api
+func routeBackend(deployment *model.Deployment, route string) string {
+	return deployment.ProjectName + "-" + route
+}
+
+// api-dtan4-app-master
+func routeIdentifier(route, identifier string) string {
+	return truncateIdentifier(strings.ToLower(route + "-" + identifier))
+}
+
+// truncateIdentifier makes identifier valid as a DNS label
+func truncateIdentifier(identifier string) string {
+	if len(identifier) > 63 {
+		identifier = identifier[0:63]
+	}
+
+	lastChar := string(identifier[len(identifier)-1])
+
+	if lastChar == "." || lastChar == "-" {
+		identifier = identifier[0:(len(identifier) - 1)]
+	}
+
+	return identifier
+}
+
+// dtan4-app-19fb23cd
+func revisionIdentifier(deployment *model.Deployment) string {
+	return strings.ToLower(deployment.App.Username + "-" + deployment.App.AppName + "-" + deployment.Revision[0:8])
+}
+
+// CurrentBranchBackend returns the backend (project name) which the branch frontend of deployment currently points at.
+// Empty string is returned if the branch has never been deployed.
+func CurrentBranchBackend(store store.Store, deployment *model.Deployment) (string, error) {
+	identifier := branchIdentifier(deployment)
+
+	if !store.HasKey(fmt.Sprintf("%s/frontends/%s/frontend", vulcandKeyBase, identifier)) {
+		return "", nil
+	}
+
+	frontend, err := getFrontend(store, identifier)
+
+	if err != nil {
+		return "", err
+	}
+
+	return frontend.BackendId, nil
+}
+
+// IsRouted reports whether any frontend other than its own revision frontend points at deployment
+func IsRouted(store store.Store, deployment *model.Deployment) (bool, error) {
+	identifiers, err := listFrontends(store)
+
+	if err != nil {
+		return false, err
+	}
+
+	for _, identifier := range identifiers {
+		if identifier == revisionIdentifier(deployment) {
+			continue
+		}
+
+		frontend, err := getFrontend(store, identifier)
+
+		if err != nil {
+			return false, err
+		}
+
+		if frontend.BackendId == deployment.ProjectName {
+			return true, nil
+		}
 	}
 
-	identifier := strings.ToLower(deployment.App.Username + "-" + deployment.App.AppName + "-" + deployment.Revision) // dtan4-app-19fb23cd
+	return false, nil
+}
+
+func deregisterBackend(store store.Store, backendID, revisionIdentifier string) error {
+	if err := unsetServer(store, backendID); err != nil {
+		return err
+	}
 
-	if err := unsetFrontend(etcd, identifier); err != nil {
+	if err := unsetFrontend(store, revisionIdentifier); err != nil {
 		return err
 	}
 
-	if err := unsetBackend(etcd, deployment.ProjectName); err != nil {
+	if err := unsetBackend(store, backendID); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func RegisterInformation(etcd *store.Etcd, deployment *model.Deployment, baseDomain string, webContainer *model.Container) ([]string, error) {
-	if err := setBackend(etcd, deployment.ProjectName); err != nil {
-		return nil, err
+// DeregisterInformation removes backends of web service and routed services (routes), and frontends pointing at their revision
+func DeregisterInformation(store store.Store, deployment *model.Deployment, routes []string) error {
+	if err := deregisterBackend(store, deployment.ProjectName, revisionIdentifier(deployment)); err != nil {
+		return err
+	}
+
+	for _, route := range routes {
+		if err := deregisterBackend(store, routeBackend(deployment, route), routeIdentifier(route, revisionIdentifier(deployment))); err != nil {
+			return err
+		}
 	}
 
-	branchIdentifier := strings.ToLower(deployment.App.Username + "-" + deployment.App.AppName + "-" + branchRegexp.ReplaceAllString(deployment.Branch, "-"))
+	return nil
+}
 
-	if len(branchIdentifier) > 63 {
-		branchIdentifier = branchIdentifier[0:63]
+// DeregisterBranch removes frontends of the branch of web service and routed services (routes),
+// including app frontends for master.
+// Backends are kept, because they may be still pointed by other frontends.
+func DeregisterBranch(store store.Store, deployment *model.Deployment, routes []string) error {
+	for _, identifier := range branchFrontendIdentifiers(deployment) {
+		if err := unsetFrontend(store, identifier); err != nil {
+			return err
+		}
+
+		for _, route := range routes {
+			if err := unsetFrontend(store, routeIdentifier(route, identifier)); err != nil {
+				return err
+			}
+		}
 	}
 
-	lastChar := string(branchIdentifier[len(branchIdentifier)-1])
+	return nil
+}
 
-	if lastChar == "." || lastChar == "-" {
-		branchIdentifier = branchIdentifier[0:(len(branchIdentifier) - 1)]
+// registerBackend registers all servers of the backend before frontends are switched to it,
+// so that frontends never point at the backend without servers.
+// Servers are overwritten in place, not to drop live servers when the routed backend is registered again.
+func registerBackend(store store.Store, backendID string, identifiers []string, baseDomain string, containers []*model.Container) error {
+	if err := setBackend(store, backendID); err != nil {
+		return err
 	}
 
-	identifiers := []string{
-		branchIdentifier, // dtan4-app-master
-		strings.ToLower(deployment.App.Username + "-" + deployment.App.AppName + "-" + deployment.Revision[0:8]), // dtan4-app-19fb23cd
+	for _, container := range containers {
+		if err := setServer(store, backendID, container, baseDomain); err != nil {
+			return err
+		}
 	}
 
-	if deployment.Branch == "master" {
-		identifiers = append(identifiers, strings.ToLower(deployment.App.Username+"-"+deployment.App.AppName)) // dtan4-app
+	if err := unsetStaleServers(store, backendID, containers); err != nil {
+		return err
 	}
 
 	for _, identifier := range identifiers {
-		if err := setFrontend(etcd, deployment.ProjectName, identifier, baseDomain); err != nil {
-			return nil, err
+		if err := setFrontend(store, backendID, identifier, baseDomain); err != nil {
+			return err
 		}
 	}
 
-	if err := setServer(etcd, deployment.ProjectName, webContainer, baseDomain); err != nil {
+	return nil
+}
+
+// RegisterInformation registers backend and frontends of web service, and ones of each routed service.
+// Every web container is registered as a server of the backend, so that requests are load-balanced among them.
+// routeContainers is a map of route name and container of the service.
+func RegisterInformation(store store.Store, deployment *model.Deployment, baseDomain string, webContainers []*model.Container, routeContainers map[string]*model.Container) ([]string, error) {
+	identifiers := frontendIdentifiers(deployment)
+
+	if err := registerBackend(store, deployment.ProjectName, identifiers, baseDomain, webContainers); err != nil {
 		return nil, err
 	}
 
+	routes := []string{}
+
+	for route := range routeContainers {
+		routes = append(routes, route)
+	}
+
+	sort.Strings(routes)
+
+	for _, route := range routes {
+		routeIdentifiers := []string{}
+
+		for _, identifier := range frontendIdentifiers(deployment) {
+			routeIdentifiers = append(routeIdentifiers, routeIdentifier(route, identifier))
+		}
+
+		if err := registerBackend(store, routeBackend(deployment, route), routeIdentifiers, baseDomain, []*model.Container{routeContainers[route]}); err != nil {
+			return nil, err
+		}
+
+		identifiers = append(identifiers, routeIdentifiers...)
+	}
+
 	return identifiers, nil
 }
@@ -0,0 +1,454 @@
+package vulcand
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/dtan4/paus-gitreceive/receiver/model"
+	"github.com/dtan4/paus-gitreceive/receiver/store"
+	"github.com/fsouza/go-dockerclient"
+)
+
+func newContainer(containerId, hostIP, hostPort string) *model.Container {
+	container, _ := model.ContainerFromInfo(nil, &docker.Container{
+		ID: containerId,
+		NetworkSettings: &docker.NetworkSettings{
+			Ports: map[docker.Port][]docker.PortBinding{
+				"8080/tcp": {{HostIP: hostIP, HostPort: hostPort}},
+			},
+		},
+	}, "")
+
+	return container
+}
+
+// recordingStore records keys written or deleted, in order
+type recordingStore struct {
+	store.Store
+	operations []string
+}
+
+func (s *recordingStore) Set(key, value string) error {
+	s.operations = append(s.operations, "set "+key)
+	return s.Store.Set(key, value)
+}
+
+func (s *recordingStore) Delete(key string) error {
+	s.operations = append(s.operations, "delete "+key)
+	return s.Store.Delete(key)
+}
+
+func (s *recordingStore) DeleteDir(key string, recursive bool) error {
+	s.operations = append(s.operations, "delete "+key)
+	return s.Store.DeleteDir(key, recursive)
+}
+
+func newDeployment(branch string) *model.Deployment {
+	app := &model.Application{
+		Repository: "dtan4-app",
+		Username:   "dtan4",
+		AppName:    "app",
+	}
+
+	return model.NewDeployment(app, branch, "19fb23cd71a4cf2eab00ad1a393e40de4ed61531", "1467181319", "/repos")
+}
+
+func TestRegisterInformation(t *testing.T) {
+	memory := store.NewMemory()
+	deployment := newDeployment("master")
+	container := newContainer("abcdef", "127.0.0.1", "32768")
+
+	identifiers, err := RegisterInformation(memory, deployment, "pausapp.com", []*model.Container{container}, nil)
+
+	if err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	expected := []string{"dtan4-app-master", "dtan4-app-19fb23cd", "dtan4-app"}
+
+	if !reflect.DeepEqual(identifiers, expected) {
+		t.Fatalf("Identifiers do not match. expected: %v, actual: %v", expected, identifiers)
+	}
+
+	backend, err := memory.Get("/vulcand/backends/dtan4-app-19fb23cd/backend")
+
+	if err != nil {
+		t.Fatalf("Backend is not registered. error: %s", err)
+	}
+
+	if backend != httpBackendJSON {
+		t.Fatalf("Backend does not match. expected: %s, actual: %s", httpBackendJSON, backend)
+	}
+
+	expectedServer := "{\"URL\":\"http://127.0.0.1:32768\"}"
+	server, err := memory.Get("/vulcand/backends/dtan4-app-19fb23cd/servers/abcdef")
+
+	if err != nil {
+		t.Fatalf("Server is not registered. error: %s", err)
+	}
+
+	if server != expectedServer {
+		t.Fatalf("Server does not match. expected: %s, actual: %s", expectedServer, server)
+	}
+
+	expectedFrontend := "{\"Type\":\"http\",\"BackendId\":\"dtan4-app-19fb23cd\",\"Route\":\"Host(`dtan4-app-master.pausapp.com`) && PathRegexp(`/`)\",\"Settings\":{\"TrustForwardHeader\":true}}"
+	frontend, err := memory.Get("/vulcand/frontends/dtan4-app-master/frontend")
+
+	if err != nil {
+		t.Fatalf("Frontend is not registered. error: %s", err)
+	}
+
+	if frontend != expectedFrontend {
+		t.Fatalf("Frontend does not match. expected: %s, actual: %s", expectedFrontend, frontend)
+	}
+}
+
+func TestDeregisterInformation(t *testing.T) {
+	memory := store.NewMemory()
+	deployment := newDeployment("master")
+	container := newContainer("abcdef", "127.0.0.1", "32768")
+
+	if _, err := RegisterInformation(memory, deployment, "pausapp.com", []*model.Container{container}, nil); err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	if err := DeregisterInformation(memory, deployment, nil); err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	for _, key := range []string{
+		"/vulcand/backends/dtan4-app-19fb23cd/backend",
+		"/vulcand/backends/dtan4-app-19fb23cd/servers",
+		"/vulcand/frontends/dtan4-app-19fb23cd",
+	} {
+		if memory.HasKey(key) {
+			t.Fatalf("%s should be deleted.", key)
+		}
+	}
+}
+
+func TestRegisterInformationWithScale(t *testing.T) {
+	memory := store.NewMemory()
+	deployment := newDeployment("master")
+	containers := []*model.Container{
+		newContainer("abcdef", "127.0.0.1", "32768"),
+		newContainer("123456", "127.0.0.1", "32769"),
+	}
+
+	if _, err := RegisterInformation(memory, deployment, "pausapp.com", containers, nil); err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	expected := []string{
+		"/vulcand/backends/dtan4-app-19fb23cd/servers/123456",
+		"/vulcand/backends/dtan4-app-19fb23cd/servers/abcdef",
+	}
+	servers, err := memory.List("/vulcand/backends/dtan4-app-19fb23cd/servers", false)
+
+	if err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	if !reflect.DeepEqual(servers, expected) {
+		t.Fatalf("Servers do not match. expected: %v, actual: %v", expected, servers)
+	}
+
+	if err := DeregisterInformation(memory, deployment, nil); err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	if memory.HasKey("/vulcand/backends/dtan4-app-19fb23cd/servers") {
+		t.Fatalf("All servers should be deleted.")
+	}
+}
+
+func TestRegisterInformationWithRoutes(t *testing.T) {
+	memory := store.NewMemory()
+	deployment := newDeployment("master")
+	container := newContainer("abcdef", "127.0.0.1", "32768")
+	routeContainers := map[string]*model.Container{
+		"admin": newContainer("123456", "127.0.0.1", "32769"),
+	}
+
+	identifiers, err := RegisterInformation(memory, deployment, "pausapp.com", []*model.Container{container}, routeContainers)
+
+	if err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	expected := []string{
+		"dtan4-app-master",
+		"dtan4-app-19fb23cd",
+		"dtan4-app",
+		"admin-dtan4-app-master",
+		"admin-dtan4-app-19fb23cd",
+		"admin-dtan4-app",
+	}
+
+	if !reflect.DeepEqual(identifiers, expected) {
+		t.Fatalf("Identifiers do not match. expected: %v, actual: %v", expected, identifiers)
+	}
+
+	expectedServer := "{\"URL\":\"http://127.0.0.1:32769\"}"
+	server, err := memory.Get("/vulcand/backends/dtan4-app-19fb23cd-admin/servers/123456")
+
+	if err != nil {
+		t.Fatalf("Server is not registered. error: %s", err)
+	}
+
+	if server != expectedServer {
+		t.Fatalf("Server does not match. expected: %s, actual: %s", expectedServer, server)
+	}
+
+	expectedFrontend := "{\"Type\":\"http\",\"BackendId\":\"dtan4-app-19fb23cd-admin\",\"Route\":\"Host(`admin-dtan4-app-master.pausapp.com`) \u0026\u0026 PathRegexp(`/`)\",\"Settings\":{\"TrustForwardHeader\":true}}"
+	frontend, err := memory.Get("/vulcand/frontends/admin-dtan4-app-master/frontend")
+
+	if err != nil {
+		t.Fatalf("Frontend is not registered. error: %s", err)
+	}
+
+	if frontend != expectedFrontend {
+		t.Fatalf("Frontend does not match. expected: %s, actual: %s", expectedFrontend, frontend)
+	}
+
+	if err := DeregisterInformation(memory, deployment, []string{"admin"}); err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	for _, key := range []string{
+		"/vulcand/backends/dtan4-app-19fb23cd-admin/backend",
+		"/vulcand/backends/dtan4-app-19fb23cd-admin/servers",
+		"/vulcand/frontends/admin-dtan4-app-19fb23cd",
+	} {
+		if memory.HasKey(key) {
+			t.Fatalf("%s should be deleted.", key)
+		}
+	}
+}
+
+func TestRegisterInformationOrder(t *testing.T) {
+	recorder := &recordingStore{Store: store.NewMemory()}
+	deployment := newDeployment("master")
+	containers := []*model.Container{
+		newContainer("abcdef", "127.0.0.1", "32768"),
+		newContainer("123456", "127.0.0.1", "32769"),
+	}
+
+	if _, err := RegisterInformation(recorder, deployment, "pausapp.com", containers, nil); err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	lastServer, firstFrontend := -1, -1
+
+	for i, operation := range recorder.operations {
+		if strings.HasPrefix(operation, "set /vulcand/backends/dtan4-app-19fb23cd/servers/") {
+			lastServer = i
+		}
+
+		if strings.HasPrefix(operation, "set /vulcand/frontends/") && firstFrontend < 0 {
+			firstFrontend = i
+		}
+	}
+
+	if lastServer < 0 || firstFrontend < 0 || lastServer > firstFrontend {
+		t.Fatalf("All servers should be registered before frontends. operations: %v", recorder.operations)
+	}
+}
+
+func TestRegisterInformationAgain(t *testing.T) {
+	memory := store.NewMemory()
+	deployment := newDeployment("master")
+
+	if _, err := RegisterInformation(memory, deployment, "pausapp.com", []*model.Container{
+		newContainer("abcdef", "127.0.0.1", "32768"),
+		newContainer("123456", "127.0.0.1", "32769"),
+	}, nil); err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	recorder := &recordingStore{Store: memory}
+
+	if _, err := RegisterInformation(recorder, deployment, "pausapp.com", []*model.Container{
+		newContainer("abcdef", "127.0.0.1", "32768"),
+		newContainer("fedcba", "127.0.0.1", "32770"),
+	}, nil); err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	for _, operation := range recorder.operations {
+		if operation == "delete /vulcand/backends/dtan4-app-19fb23cd/servers" || operation == "delete /vulcand/backends/dtan4-app-19fb23cd/servers/abcdef" {
+			t.Fatalf("Live server should not be deleted. operations: %v", recorder.operations)
+		}
+	}
+
+	expected := []string{
+		"/vulcand/backends/dtan4-app-19fb23cd/servers/abcdef",
+		"/vulcand/backends/dtan4-app-19fb23cd/servers/fedcba",
+	}
+	servers, err := memory.List("/vulcand/backends/dtan4-app-19fb23cd/servers", false)
+
+	if err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	if !reflect.DeepEqual(servers, expected) {
+		t.Fatalf("Servers do not match. expected: %v, actual: %v", expected, servers)
+	}
+}
+
+func TestCurrentBranchBackend(t *testing.T) {
+	memory := store.NewMemory()
+	deployment := newDeployment("master")
+	container := newContainer("abcdef", "127.0.0.1", "32768")
+
+	backend, err := CurrentBranchBackend(memory, deployment)
+
+	if err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	if backend != "" {
+		t.Fatalf("Backend should be empty before the branch is deployed. actual: %s", backend)
+	}
+
+	if _, err := RegisterInformation(memory, deployment, "pausapp.com", []*model.Container{container}, nil); err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	backend, err = CurrentBranchBackend(memory, deployment)
+
+	if err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	if backend != deployment.ProjectName {
+		t.Fatalf("Backend does not match. expected: %s, actual: %s", deployment.ProjectName, backend)
+	}
+}
+
+func TestIsRouted(t *testing.T) {
+	memory := store.NewMemory()
+	oldDeployment := newDeployment("master")
+	newDeployment := model.NewDeployment(oldDeployment.App, "master", "3e634e41d5a819a7586c621a6322ee4d5085232c", "1467181320", "/repos")
+	container := newContainer("abcdef", "127.0.0.1", "32768")
+
+	if _, err := RegisterInformation(memory, oldDeployment, "pausapp.com", []*model.Container{container}, nil); err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	routed, err := IsRouted(memory, oldDeployment)
+
+	if err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	if !routed {
+		t.Fatalf("Deployment should be routed from branch frontend.")
+	}
+
+	if _, err := RegisterInformation(memory, newDeployment, "pausapp.com", []*model.Container{container}, nil); err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	routed, err = IsRouted(memory, oldDeployment)
+
+	if err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	if routed {
+		t.Fatalf("Deployment should not be routed after cutover.")
+	}
+
+	if err := DeregisterInformation(memory, oldDeployment, nil); err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	if err := DeregisterInformation(memory, oldDeployment, nil); err != nil {
+		t.Fatalf("Deregistering twice should not raise error. error: %s", err)
+	}
+}
+
+func TestDeregisterBranch(t *testing.T) {
+	memory := store.NewMemory()
+	deployment := newDeployment("feature")
+	container := newContainer("abcdef", "127.0.0.1", "32768")
+	routeContainers := map[string]*model.Container{
+		"admin": newContainer("123456", "127.0.0.1", "32769"),
+	}
+
+	if _, err := RegisterInformation(memory, deployment, "pausapp.com", []*model.Container{container}, routeContainers); err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	if err := DeregisterBranch(memory, deployment, []string{"admin"}); err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	for _, key := range []string{
+		"/vulcand/frontends/dtan4-app-feature",
+		"/vulcand/frontends/admin-dtan4-app-feature",
+	} {
+		if memory.HasKey(key) {
+			t.Fatalf("%s should be deleted.", key)
+		}
+	}
+
+	for _, key := range []string{
+		"/vulcand/frontends/dtan4-app-19fb23cd/frontend",
+		"/vulcand/backends/dtan4-app-19fb23cd/backend",
+	} {
+		if !memory.HasKey(key) {
+			t.Fatalf("%s should not be deleted.", key)
+		}
+	}
+
+	routed, err := IsRouted(memory, deployment)
+
+	if err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	if routed {
+		t.Fatalf("Deployment should not be routed after its branch is deregistered.")
+	}
+}
+
+func TestDeregisterBranchMaster(t *testing.T) {
+	memory := store.NewMemory()
+	deployment := newDeployment("master")
+	container := newContainer("abcdef", "127.0.0.1", "32768")
+	routeContainers := map[string]*model.Container{
+		"admin": newContainer("123456", "127.0.0.1", "32769"),
+	}
+
+	if _, err := RegisterInformation(memory, deployment, "pausapp.com", []*model.Container{container}, routeContainers); err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	if err := DeregisterBranch(memory, deployment, []string{"admin"}); err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	for _, key := range []string{
+		"/vulcand/frontends/dtan4-app-master",
+		"/vulcand/frontends/dtan4-app",
+		"/vulcand/frontends/admin-dtan4-app-master",
+		"/vulcand/frontends/admin-dtan4-app",
+	} {
+		if memory.HasKey(key) {
+			t.Fatalf("%s should be deleted.", key)
+		}
+	}
+
+	routed, err := IsRouted(memory, deployment)
+
+	if err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	if routed {
+		t.Fatalf("Deployment should not be routed after master is deregistered.")
+	}
+}
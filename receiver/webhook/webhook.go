@@ -0,0 +1,161 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	EventFailed    = "failed"
+	EventRotated   = "rotated"
+	EventStarted   = "started"
+	EventSucceeded = "succeeded"
+
+	EventHeader     = "X-Paus-Event"
+	SignatureHeader = "X-Paus-Signature"
+
+	defaultMaxAttempts    = 3
+	defaultRetryWait      = 1 * time.Second
+	requestTimeout        = 10 * time.Second
+	signaturePrefix       = "sha256="
+	statusTooManyRequests = 429
+)
+
+// Payload is JSON body sent to webhook endpoints
+type Payload struct {
+	Event      string   `json:"event"`
+	User       string   `json:"user"`
+	App        string   `json:"app"`
+	Repository string   `json:"repository"`
+	Branch     string   `json:"branch"`
+	Revision   string   `json:"revision"`
+	Timestamp  string   `json:"timestamp"`
+	URLs       []string `json:"urls"`
+	Error      string   `json:"error,omitempty"`
+}
+
+// Notifier posts payloads to webhook endpoints.
+// Request is retried with exponential backoff on connection failure, 429 and 5xx status.
+type Notifier struct {
+	MaxAttempts int
+	RetryWait   time.Duration
+	Secret      string
+	URLs        []string
+
+	client *http.Client
+}
+
+func NewNotifier(urls []string, secret string) *Notifier {
+	return &Notifier{
+		MaxAttempts: defaultMaxAttempts,
+		RetryWait:   defaultRetryWait,
+		Secret:      secret,
+		URLs:        urls,
+		client: &http.Client{
+			Timeout: requestTimeout,
+		},
+	}
+}
+
+// Sign returns HMAC-SHA256 signature of body, formatted as sha256=<hex digest>
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+
+	return signaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Notify sends payload to all endpoints. It tries every endpoint even if some of them fail.
+func (n *Notifier) Notify(payload *Payload) error {
+	if len(n.URLs) == 0 {
+		return nil
+	}
+
+	body, err := json.Marshal(payload)
+
+	if err != nil {
+		return errors.Wrap(err, "Failed to generate webhook payload JSON.")
+	}
+
+	messages := []string{}
+
+	for _, url := range n.URLs {
+		if err := n.send(url, payload.Event, body); err != nil {
+			messages = append(messages, err.Error())
+		}
+	}
+
+	if len(messages) > 0 {
+		return errors.Errorf("Failed to send webhooks. errors: %s", strings.Join(messages, ", "))
+	}
+
+	return nil
+}
+
+func (n *Notifier) send(url, event string, body []byte) error {
+	var lastErr error
+
+	wait := n.RetryWait
+
+	for attempt := 1; attempt <= n.MaxAttempts; attempt++ {
+		retry, err := n.post(url, event, body)
+
+		if err == nil {
+			return nil
+		}
+
+		lastErr = err
+
+		if !retry || attempt == n.MaxAttempts {
+			break
+		}
+
+		time.Sleep(wait)
+		wait *= 2
+	}
+
+	return errors.Wrapf(lastErr, "url: %s", url)
+}
+
+// post sends a single request, and returns whether it is worth retrying on failure
+func (n *Notifier) post(url, event string, body []byte) (bool, error) {
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+
+	if err != nil {
+		return false, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, event)
+
+	if n.Secret != "" {
+		req.Header.Set(SignatureHeader, Sign(n.Secret, body))
+	}
+
+	resp, err := n.client.Do(req)
+
+	if err != nil {
+		return true, err
+	}
+
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+
+	retry := resp.StatusCode == statusTooManyRequests || resp.StatusCode >= 500
+
+	return retry, errors.Errorf("Unexpected status. status: %d", resp.StatusCode)
+}
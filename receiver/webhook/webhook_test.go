@@ -0,0 +1,115 @@
+package webhook
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func newPayload() *Payload {
+	return &Payload{
+		Event:      EventSucceeded,
+		User:       "dtan4",
+		App:        "app",
+		Repository: "dtan4-app",
+		Branch:     "master",
+		Revision:   "19fb23cd71a4cf2eab00ad1a393e40de4ed61531",
+		Timestamp:  "1467181319",
+		URLs:       []string{"http://dtan4-app-master.pausapp.com"},
+	}
+}
+
+func TestSign(t *testing.T) {
+	expected := "sha256=f7bc83f430538424b13298e6aa6fb143ef4d59a14946175997479dbc2d1a3cd8"
+	actual := Sign("key", []byte("The quick brown fox jumps over the lazy dog"))
+
+	if actual != expected {
+		t.Fatalf("Signature does not match. expected: %s, actual: %s", expected, actual)
+	}
+}
+
+func TestNotify(t *testing.T) {
+	var (
+		body      []byte
+		event     string
+		signature string
+	)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = ioutil.ReadAll(r.Body)
+		event = r.Header.Get(EventHeader)
+		signature = r.Header.Get(SignatureHeader)
+	}))
+	defer server.Close()
+
+	notifier := NewNotifier([]string{server.URL}, "secret")
+	payload := newPayload()
+
+	if err := notifier.Notify(payload); err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+
+	var actual Payload
+
+	if err := json.Unmarshal(body, &actual); err != nil {
+		t.Fatalf("Payload is not JSON. body: %s", string(body))
+	}
+
+	if !reflect.DeepEqual(&actual, payload) {
+		t.Fatalf("Payload does not match. expected: %+v, actual: %+v", payload, actual)
+	}
+
+	if event != EventSucceeded {
+		t.Fatalf("Event header does not match. expected: %s, actual: %s", EventSucceeded, event)
+	}
+
+	if signature != Sign("secret", body) {
+		t.Fatalf("Signature header does not match. expected: %s, actual: %s", Sign("secret", body), signature)
+	}
+}
+
+func TestNotifyRetry(t *testing.T) {
+	testcases := []struct {
+		statuses []int
+		attempts int
+		success  bool
+	}{
+		{[]int{200}, 1, true},
+		{[]int{503, 502, 200}, 3, true},
+		{[]int{429, 200}, 2, true},
+		{[]int{500, 500, 500, 200}, 3, false},
+		{[]int{404, 200}, 1, false},
+	}
+
+	for _, tc := range testcases {
+		attempts := 0
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(tc.statuses[attempts])
+			attempts++
+		}))
+
+		notifier := NewNotifier([]string{server.URL}, "")
+		notifier.RetryWait = 0
+
+		err := notifier.Notify(newPayload())
+		server.Close()
+
+		if (err == nil) != tc.success {
+			t.Fatalf("Result does not match. statuses: %v, expected success: %t, error: %v", tc.statuses, tc.success, err)
+		}
+
+		if attempts != tc.attempts {
+			t.Fatalf("Number of attempts does not match. statuses: %v, expected: %d, actual: %d", tc.statuses, tc.attempts, attempts)
+		}
+	}
+}
+
+func TestNotifyWithoutURLs(t *testing.T) {
+	if err := NewNotifier([]string{}, "secret").Notify(newPayload()); err != nil {
+		t.Fatalf("Unexpected error has been raised. error: %s", err)
+	}
+}